@@ -0,0 +1,617 @@
+package sheikh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations and constants of OrientDB binary protocol used by BinaryTransport.
+const (
+	binaryProtocolVersion = 36
+
+	opDbOpen       = 3
+	opDbClose      = 5
+	opRecordLoad   = 30
+	opRecordCreate = 31
+	opRecordUpdate = 32
+	opRecordDelete = 33
+	opCommand      = 41
+
+	statusOk    = 0
+	statusError = 1
+	statusPush  = 3
+)
+
+/* BinaryTransport is Transport using OrientDB binary protocol over TCP, which spares URL-encoding and JSON parsing of
+REST requests. It opens one session (without tokens) in Authenticate, and sends requests over it one at a time.
+Records are serialized in CSV format. Set it in Connection.Transport before Connect, e.g.
+   c.Transport = &BinaryTransport{}
+Connection.Client.Timeout is used as timeout of each request and, unless ConnectTimeout is set, of connecting.
+Connecting is retried Connection.Retries times. */
+type BinaryTransport struct {
+	Port           string // 2424 if empty
+	ConnectTimeout time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	rw      *bufio.ReadWriter
+	session int32
+}
+
+// binaryWriter buffers request of the binary protocol; values are written in big endian order.
+type binaryWriter struct {
+	buff []byte
+}
+
+func (w *binaryWriter) byte(b byte) {
+	w.buff = append(w.buff, b)
+}
+
+func (w *binaryWriter) bool(b bool) {
+	if b {
+		w.byte(1)
+	} else {
+		w.byte(0)
+	}
+}
+
+func (w *binaryWriter) short(n int16) {
+	w.buff = binary.BigEndian.AppendUint16(w.buff, uint16(n))
+}
+
+func (w *binaryWriter) int(n int32) {
+	w.buff = binary.BigEndian.AppendUint32(w.buff, uint32(n))
+}
+
+func (w *binaryWriter) long(n int64) {
+	w.buff = binary.BigEndian.AppendUint64(w.buff, uint64(n))
+}
+
+// bytes writes length-prefixed bytes; nil is written as length -1.
+func (w *binaryWriter) bytes(b []byte) {
+	if b == nil {
+		w.int(-1)
+		return
+	}
+	w.int(int32(len(b)))
+	w.buff = append(w.buff, b...)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+// binaryReader reads response of the binary protocol, remembering the first error.
+type binaryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *binaryReader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	buff := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, buff)
+	return buff
+}
+
+func (r *binaryReader) byte() byte {
+	return r.read(1)[0]
+}
+
+func (r *binaryReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *binaryReader) short() int16 {
+	return int16(binary.BigEndian.Uint16(r.read(2)))
+}
+
+func (r *binaryReader) int() int32 {
+	return int32(binary.BigEndian.Uint32(r.read(4)))
+}
+
+func (r *binaryReader) long() int64 {
+	return int64(binary.BigEndian.Uint64(r.read(8)))
+}
+
+// bytes reads length-prefixed bytes, returning nil for length -1.
+func (r *binaryReader) bytes() []byte {
+	n := r.int()
+	if n < 0 || r.err != nil {
+		return nil
+	}
+	if n > 1<<30 {
+		r.err = errors.New(fmt.Sprintf("Binary protocol: bad length %v", n))
+		return nil
+	}
+	return r.read(int(n))
+}
+
+func (r *binaryReader) string() string {
+	return string(r.bytes())
+}
+
+// parseRid splits RID (e.g. #9:1) into cluster ID and position.
+func parseRid(rid string) (int16, int64, error) {
+	parts := strings.SplitN(strings.TrimPrefix(rid, "#"), ":", 2)
+	if len(parts) == 2 {
+		cluster, clusterErr := strconv.ParseInt(parts[0], 10, 16)
+		position, positionErr := strconv.ParseInt(parts[1], 10, 64)
+		if clusterErr == nil && positionErr == nil {
+			return int16(cluster), position, nil
+		}
+	}
+	return 0, 0, errors.New(fmt.Sprintf("Bad RID %q", rid))
+}
+
+func (t *BinaryTransport) close() {
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+/* request sends request with the operation and payload written by fn, and calls read to read payload of the
+response. What describes the request in error messages. Errors of the connection close it, so the next request
+reconnects. */
+func (t *BinaryTransport) request(c *Connection, op byte, what string, fn func(w *binaryWriter),
+	read func(r *binaryReader)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		if err := t.open(c); err != nil {
+			return err
+		}
+	}
+	return t.roundTrip(c, op, what, fn, read)
+}
+
+func (t *BinaryTransport) roundTrip(c *Connection, op byte, what string, fn func(w *binaryWriter),
+	read func(r *binaryReader)) error {
+	w := &binaryWriter{}
+	w.byte(op)
+	w.int(t.session)
+	fn(w)
+	if (*c).Client.Timeout > 0 {
+		t.conn.SetDeadline(time.Now().Add((*c).Client.Timeout))
+	}
+	if _, err := t.rw.Write(w.buff); err != nil {
+		t.close()
+		return err
+	}
+	if err := t.rw.Flush(); err != nil {
+		t.close()
+		return err
+	}
+	r := &binaryReader{r: t.rw}
+	status := r.byte()
+	for status == statusPush && r.err == nil { // pushed by server, e.g. schema change
+		r.bytes()
+		status = r.byte()
+	}
+	r.int() // session
+	if r.err != nil {
+		t.close()
+		return r.err
+	}
+	if status == statusError {
+		var msgs []string
+		for r.byte() == 1 && r.err == nil {
+			class, msg := r.string(), r.string()
+			msgs = append(msgs, class+": "+msg)
+		}
+		r.bytes() // serialized exception
+		if r.err != nil {
+			t.close()
+			return r.err
+		}
+		return errors.New(fmt.Sprintf("%s failed, server error: %s", what, strings.Join(msgs, "; ")))
+	}
+	if status != statusOk {
+		t.close()
+		return errors.New(fmt.Sprintf("%s: unexpected response status %v", what, status))
+	}
+	read(r)
+	if r.err != nil {
+		t.close()
+	}
+	return r.err
+}
+
+// open connects to the server and opens the database.
+func (t *BinaryTransport) open(c *Connection) error {
+	port := t.Port
+	if port == "" {
+		port = "2424"
+	}
+	timeout := t.ConnectTimeout
+	if timeout == 0 {
+		timeout = (*c).Client.Timeout
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort((*c).Server, port), timeout)
+	for attempt := 1; attempt <= (*c).Retries && err != nil; attempt++ {
+		time.Sleep(time.Duration(attempt) * retryDelay)
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort((*c).Server, port), timeout)
+	}
+	if err != nil {
+		return err
+	}
+	t.conn, t.rw = conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	r := &binaryReader{r: t.rw}
+	if version := r.short(); r.err != nil || version < binaryProtocolVersion {
+		t.close()
+		if r.err != nil {
+			return r.err
+		}
+		return errors.New(fmt.Sprintf("Connecting to OrientDB: server speaks protocol %v, at least %v is needed",
+			version, binaryProtocolVersion))
+	}
+	t.session = -1
+	err = t.roundTrip(c, opDbOpen, "Connecting to OrientDB", func(w *binaryWriter) {
+		w.string("sheikh")
+		w.string("1.0")
+		w.short(binaryProtocolVersion)
+		w.bytes(nil) // client ID
+		w.string("ORecordDocument2csv")
+		w.bool(false) // token session
+		w.bool(false) // push notifications
+		w.bool(false) // stats
+		w.string((*c).Database)
+		w.string((*c).Username)
+		w.string((*c).Password)
+	}, func(r *binaryReader) {
+		t.session = r.int()
+		r.bytes() // token
+		for clusters := r.short(); clusters > 0 && r.err == nil; clusters-- {
+			r.string()
+			r.short()
+		}
+		r.bytes()  // cluster configuration
+		r.string() // release
+	})
+	if err != nil {
+		t.close()
+	}
+	return err
+}
+
+/* readRecord reads record from command result. Records without RID (e.g. projections) are returned without @rid,
+nil is returned for null records. */
+func (r *binaryReader) readRecord() (interface{}, error) {
+	switch r.short() {
+	case -2:
+		return nil, nil
+	case -3:
+		cluster, position := r.short(), r.long()
+		return fmt.Sprintf("#%v:%v", cluster, position), nil
+	}
+	r.byte() // record type
+	cluster, position, version := r.short(), r.long(), r.int()
+	content := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	rid := fmt.Sprintf("#%v:%v", cluster, position)
+	if cluster < 0 {
+		rid = ""
+	}
+	return recordMap(rid, version, content)
+}
+
+/* skipCollectionChanges reads changes of tree-based RidBags sent after record is created or updated. Each one has
+UUID of the collection (two longs), file ID, page index (longs) and page offset (int). */
+func (r *binaryReader) skipCollectionChanges() {
+	for changes := r.int(); changes > 0 && r.err == nil; changes-- {
+		r.read(16)
+		r.long()
+		r.long()
+		r.int()
+	}
+}
+
+// recordMap decodes CSV content of the record into map, adding metadata fields as REST API does.
+func recordMap(rid string, version int32, content []byte) (map[string]interface{}, error) {
+	class, fields, err := UnmarshalRecordCSV(content)
+	if err != nil {
+		return nil, err
+	}
+	fields["@type"], fields["@version"] = "d", float64(version)
+	if rid != "" {
+		fields["@rid"] = rid
+	}
+	if class != "" {
+		fields["@class"] = class
+	}
+	return fields, nil
+}
+
+// readCommandResult reads result of synchronous command, wrapping simple values into records as REST API does.
+func (r *binaryReader) readCommandResult() ([]interface{}, error) {
+	ret := []interface{}{}
+	switch kind := r.byte(); kind {
+	case 'n':
+	case 'r', 'w':
+		rec, err := r.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := rec.(map[string]interface{}); ok && kind == 'w' {
+			rec = m["result"]
+		}
+		if rec != nil {
+			ret = append(ret, rec)
+		}
+	case 'l', 's':
+		for n := r.int(); n > 0 && r.err == nil; n-- {
+			rec, err := r.readRecord()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, rec)
+		}
+	case 'a':
+		val, err := csvScalar(r.string())
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, map[string]interface{}{"@type": "d", "@version": 0.0, "value": val})
+	default:
+		return nil, errors.New(fmt.Sprintf("Binary protocol: unknown result type %q", kind))
+	}
+	for r.byte() == 2 && r.err == nil { // records prefetched by fetch plan
+		if _, err := r.readRecord(); err != nil {
+			return nil, err
+		}
+	}
+	for ind, rec := range ret {
+		if rid, ok := rec.(string); ok {
+			ret[ind] = map[string]interface{}{"@type": "d", "@version": 0.0, "value": rid}
+		}
+	}
+	return ret, r.err
+}
+
+func (t *BinaryTransport) Authenticate(c *Connection) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.close()
+	return t.open(c)
+}
+
+// command sends command of given class (c for SQL commands, s for scripts) and returns its result.
+func (t *BinaryTransport) command(c *Connection, what string, payload *binaryWriter) ([]interface{}, error) {
+	var result []interface{}
+	var resultErr error
+	err := t.request(c, opCommand, what, func(w *binaryWriter) {
+		w.byte('s') // synchronous mode
+		w.bytes(payload.buff)
+	}, func(r *binaryReader) {
+		result, resultErr = r.readCommandResult()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, resultErr
+}
+
+func (t *BinaryTransport) Command(c *Connection, text string) ([]interface{}, error) {
+	payload := &binaryWriter{}
+	payload.string("c")
+	payload.string(text)
+	payload.bool(false) // simple parameters
+	payload.bool(false) // complex parameters
+	return t.command(c, fmt.Sprintf("Command %v", text), payload)
+}
+
+/* Batch runs the script as SQL script command. In transaction, it's enclosed in BEGIN and COMMIT statements, with
+COMMIT put before the final RETURN statement, if there is one. */
+func (t *BinaryTransport) Batch(c *Connection, transaction bool, script []string) ([]interface{}, error) {
+	statements := script
+	if transaction {
+		statements = append([]string{"BEGIN"}, script...)
+		last := len(statements) - 1
+		if len(script) != 0 && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(statements[last])), "RETURN") {
+			statements = append(append(statements[:last:last], "COMMIT"), statements[last])
+		} else {
+			statements = append(statements, "COMMIT")
+		}
+	}
+	payload := &binaryWriter{}
+	payload.string("s")
+	payload.string("sql")
+	payload.string(strings.Join(statements, ";\n"))
+	payload.bool(false)
+	payload.bool(false)
+	return t.command(c, fmt.Sprintf("Batch %q", script), payload)
+}
+
+// Document loads the record with RECORD_LOAD operation.
+func (t *BinaryTransport) Document(c *Connection, rid string) (interface{}, error) {
+	rec, err := t.LoadRecord(c, rid)
+	if rec == nil {
+		return nil, err // avoid non-nil interface holding nil map
+	}
+	return rec, err
+}
+
+// LoadRecord loads the record of given RID, returning nil if there is no such record.
+func (t *BinaryTransport) LoadRecord(c *Connection, rid string) (map[string]interface{}, error) {
+	cluster, position, err := parseRid(rid)
+	if err != nil {
+		return nil, err
+	}
+	var rec map[string]interface{}
+	var recErr error
+	err = t.request(c, opRecordLoad, "Loading "+rid, func(w *binaryWriter) {
+		w.short(cluster)
+		w.long(position)
+		w.string("") // fetch plan
+		w.bool(false)
+		w.bool(false)
+	}, func(r *binaryReader) {
+		for r.byte() == 1 && r.err == nil {
+			r.byte() // record type
+			version, content := r.int(), r.bytes()
+			if r.err == nil && rec == nil {
+				rec, recErr = recordMap(rid, version, content)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, recErr
+}
+
+/* CreateRecord creates record of the class with given fields with RECORD_CREATE operation, and returns its RID and
+version. */
+func (t *BinaryTransport) CreateRecord(c *Connection, class string, fields map[string]interface{}) (string, int,
+	error) {
+	var rid string
+	var version int32
+	err := t.request(c, opRecordCreate, "Creating record of "+class, func(w *binaryWriter) {
+		w.short(-1) // cluster chosen by class
+		w.bytes(MarshalRecordCSV(class, fields))
+		w.byte('d')
+		w.byte(0) // synchronous mode
+	}, func(r *binaryReader) {
+		cluster, position := r.short(), r.long()
+		version = r.int()
+		rid = fmt.Sprintf("#%v:%v", cluster, position)
+		r.skipCollectionChanges()
+	})
+	return rid, int(version), err
+}
+
+/* UpdateRecord replaces content of the record with RECORD_UPDATE operation and returns its new version. The update
+fails if the record was changed since given version (pass -1 to skip the check). */
+func (t *BinaryTransport) UpdateRecord(c *Connection, rid string, version int, class string,
+	fields map[string]interface{}) (int, error) {
+	cluster, position, err := parseRid(rid)
+	if err != nil {
+		return 0, err
+	}
+	var newVersion int32
+	err = t.request(c, opRecordUpdate, "Updating "+rid, func(w *binaryWriter) {
+		w.short(cluster)
+		w.long(position)
+		w.bool(true) // update content
+		w.bytes(MarshalRecordCSV(class, fields))
+		w.int(int32(version))
+		w.byte('d')
+		w.byte(0)
+	}, func(r *binaryReader) {
+		newVersion = r.int()
+		r.skipCollectionChanges()
+	})
+	return int(newVersion), err
+}
+
+/* DeleteRecord deletes the record with RECORD_DELETE operation, telling whether it existed. The deletion fails if
+the record was changed since given version (pass -1 to skip the check). */
+func (t *BinaryTransport) DeleteRecord(c *Connection, rid string, version int) (bool, error) {
+	cluster, position, err := parseRid(rid)
+	if err != nil {
+		return false, err
+	}
+	var deleted bool
+	err = t.request(c, opRecordDelete, "Deleting "+rid, func(w *binaryWriter) {
+		w.short(cluster)
+		w.long(position)
+		w.int(int32(version))
+		w.byte(0)
+	}, func(r *binaryReader) {
+		deleted = r.bool()
+	})
+	return deleted, err
+}
+
+// typeNames maps type IDs used in metadata:schema to type names.
+var typeNames = []string{"BOOLEAN", "INTEGER", "SHORT", "LONG", "FLOAT", "DOUBLE", "DATETIME", "STRING", "BINARY",
+	"EMBEDDED", "EMBEDDEDLIST", "EMBEDDEDSET", "EMBEDDEDMAP", "LINK", "LINKLIST", "LINKSET", "LINKMAP", "BYTE",
+	"TRANSIENT", "DATE", "CUSTOM", "DECIMAL", "LINKBAG", "ANY"}
+
+// typeName returns name of the type given by name or ID.
+func typeName(val interface{}) interface{} {
+	if id, ok := val.(float64); ok && int(id) >= 0 && int(id) < len(typeNames) {
+		return typeNames[int(id)]
+	}
+	return val
+}
+
+/* Database describes classes and indexes of the database, built from metadata:schema and metadata:indexmanager
+queries in the shape returned by /database REST endpoint. */
+func (t *BinaryTransport) Database(c *Connection) (interface{}, error) {
+	classes, err := t.Command(c, "SELECT expand(classes) FROM metadata:schema")
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := t.Command(c, "SELECT expand(indexes) FROM metadata:indexmanager")
+	if err != nil {
+		return nil, err
+	}
+	for _, rawClass := range classes {
+		cl, ok := rawClass.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cl["clusters"] == nil {
+			cl["clusters"] = cl["clusterIds"]
+		}
+		props, _ := cl["properties"].([]interface{})
+		for _, rawProp := range props {
+			if p, ok := rawProp.(map[string]interface{}); ok {
+				p["type"], p["linkedType"] = typeName(p["type"]), typeName(p["linkedType"])
+			}
+		}
+		var clIndexes []interface{}
+		for _, rawIdx := range indexes {
+			idx, _ := rawIdx.(map[string]interface{})
+			def, _ := idx["indexDefinition"].(map[string]interface{})
+			if def == nil || def["className"] != cl["name"] {
+				continue
+			}
+			fields, _ := def["fields"].([]interface{})
+			if field, ok := def["field"]; ok {
+				fields = []interface{}{field}
+			}
+			if defs, ok := def["indexDefinitions"].([]interface{}); ok { // composite index
+				for _, rawSub := range defs {
+					if sub, ok := rawSub.(map[string]interface{}); ok {
+						fields = append(fields, sub["field"])
+					}
+				}
+			}
+			clIndexes = append(clIndexes, map[string]interface{}{"name": idx["name"], "type": idx["type"],
+				"fields": fields})
+		}
+		cl["indexes"] = clIndexes
+	}
+	return map[string]interface{}{"classes": classes}, nil
+}
+
+// Close closes the database session and the TCP connection.
+func (t *BinaryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	w := &binaryWriter{}
+	w.byte(opDbClose)
+	w.int(t.session)
+	t.rw.Write(w.buff)
+	t.rw.Flush()
+	t.close()
+	return nil
+}
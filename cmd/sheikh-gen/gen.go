@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"reflect"
+	"sheikh"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// goType describes how values of an OrientDB type are read from Vertex/Edge.
+type goType struct {
+	Name, Getter string
+}
+
+var goTypes = map[string]goType{
+	"STRING":       {"string", "PropStr"},
+	"LINK":         {"string", "PropStr"},
+	"DATE":         {"string", "PropStr"}, // REST API returns dates as strings
+	"DATETIME":     {"string", "PropStr"},
+	"BOOLEAN":      {"bool", "PropBool"},
+	"BYTE":         {"int", "PropInt"},
+	"SHORT":        {"int", "PropInt"},
+	"INTEGER":      {"int", "PropInt"},
+	"LONG":         {"int64", "PropInt64"},
+	"FLOAT":        {"float64", "PropFloat"},
+	"DOUBLE":       {"float64", "PropFloat"},
+	"DECIMAL":      {"float64", "PropFloat"},
+	"EMBEDDED":     {"map[string]interface{}", "PropObj"},
+	"EMBEDDEDMAP":  {"map[string]interface{}", "PropObj"},
+	"LINKMAP":      {"map[string]interface{}", "PropObj"},
+	"EMBEDDEDLIST": {"[]interface{}", "PropArr"},
+	"EMBEDDEDSET":  {"[]interface{}", "PropArr"},
+	"LINKLIST":     {"[]interface{}", "PropArr"},
+	"LINKSET":      {"[]interface{}", "PropArr"},
+	"LINKBAG":      {"[]interface{}", "PropArr"},
+}
+
+type genProperty struct {
+	Name, Ident string
+	Type        goType
+	Indexed     bool
+}
+
+type genClass struct {
+	Name, Ident string
+	Edge        bool // wraps sheikh.Edge instead of sheikh.Vertex
+	Abstract    bool
+	Properties  []genProperty
+}
+
+// identifier converts OrientDB name to an exported Go identifier, e.g. "first_name" to "FirstName".
+func identifier(name string) string {
+	var ret []rune
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		ret = append(ret, r)
+	}
+	if len(ret) == 0 || unicode.IsDigit(ret[0]) {
+		ret = append([]rune("X"), ret...)
+	}
+	return string(ret)
+}
+
+// promotedNames returns names of exported fields and methods promoted to generated types from the embedded type.
+func promotedNames(embedded interface{}) map[string]bool {
+	ret := make(map[string]bool)
+	typ := reflect.TypeOf(embedded)
+	for ind := 0; ind < typ.NumMethod(); ind++ {
+		ret[typ.Method(ind).Name] = true
+	}
+	for _, field := range reflect.VisibleFields(typ.Elem()) {
+		if field.IsExported() {
+			ret[field.Name] = true
+		}
+	}
+	return ret
+}
+
+// Names promoted from *sheikh.Vertex and *sheikh.Edge, which generated methods can't use.
+var vertexNames, edgeNames = promotedNames(&sheikh.Vertex{}), promotedNames(&sheikh.Edge{})
+
+/* collectClasses returns descriptions of vertex and edge classes from the schema (or only of the ones listed in
+only), sorted by name. Accessors of properties which would shadow methods or fields of sheikh.Vertex/Edge (e.g.
+SetProps for props property) get Prop suffix, e.g. PropsProp and SetPropsProp. */
+func collectClasses(s *sheikh.Schema, only []string) ([]genClass, error) {
+	wanted := make(map[string]bool)
+	for _, name := range only {
+		if s.Class(name) == nil {
+			return nil, errors.New(fmt.Sprintf("class %s not found in the schema", name))
+		}
+		wanted[name] = true
+	}
+	var ret []genClass
+	idents := make(map[string]string)
+	for _, cl := range s.Classes {
+		isEdge := s.IsA(cl.Name, "E")
+		if cl.Name == "V" || cl.Name == "E" || (!isEdge && !s.IsA(cl.Name, "V")) {
+			continue
+		}
+		if len(only) != 0 && !wanted[cl.Name] {
+			continue
+		}
+		gc := genClass{Name: cl.Name, Ident: identifier(cl.Name), Edge: isEdge, Abstract: cl.Abstract}
+		if other, present := idents[gc.Ident]; present {
+			return nil, errors.New(fmt.Sprintf("classes %s and %s both map to Go type %s", other, cl.Name, gc.Ident))
+		}
+		idents[gc.Ident] = cl.Name
+
+		indexed := make(map[string]bool)
+		for _, idx := range s.ClassIndexes(cl.Name) {
+			if len(idx.Fields) == 1 {
+				indexed[idx.Fields[0]] = true
+			}
+		}
+		promoted := vertexNames
+		if isEdge {
+			promoted = edgeNames
+		}
+		methods := make(map[string]string) // accessors generated so far, by name, with their properties
+		for _, p := range s.AllProperties(cl.Name) {
+			if isEdge && (p.Name == "out" || p.Name == "in") {
+				continue // available with From and To
+			}
+			typ, known := goTypes[strings.ToUpper(p.Type)]
+			if !known {
+				typ = goType{"interface{}", "Prop"}
+			}
+			gp := genProperty{Name: p.Name, Ident: identifier(p.Name), Type: typ, Indexed: indexed[p.Name]}
+			if promoted[gp.Ident] || promoted["Set"+gp.Ident] {
+				gp.Ident += "Prop"
+			}
+			for _, method := range []string{gp.Ident, "Set" + gp.Ident} {
+				if other, present := methods[method]; present {
+					return nil, errors.New(fmt.Sprintf("properties %s.%s and %s.%s both map to method %s",
+						cl.Name, other, cl.Name, p.Name, method))
+				}
+				methods[method] = p.Name
+			}
+			gc.Properties = append(gc.Properties, gp)
+		}
+		sort.Slice(gc.Properties, func(i, j int) bool { return gc.Properties[i].Name < gc.Properties[j].Name })
+		ret = append(ret, gc)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+var codeTemplate = template.Must(template.New("code").Parse(`// Code generated by sheikh-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .NeedJson}}
+	"encoding/json"
+{{- end}}
+	"sheikh"
+)
+
+{{range .Classes}}{{$cl := .}}
+// Names of {{.Name}} class and its properties.
+const (
+	Class{{.Ident}} = {{printf "%q" .Name}}
+{{- range .Properties}}
+	Prop{{$cl.Ident}}{{.Ident}} = {{printf "%q" .Name}}
+{{- end}}
+)
+
+{{if .Edge -}}
+// {{.Ident}} is an edge of {{.Name}} class.
+type {{.Ident}} struct {
+	*sheikh.Edge
+}
+{{- if not .Abstract}}
+
+// New{{.Ident}} returns a new {{.Name}} edge between the vertexes; it has to be inserted with InsertEdge.
+func New{{.Ident}}(from, to *sheikh.Vertex) {{.Ident}} {
+	e := sheikh.CreateEdge(from, Class{{.Ident}}, to)
+	return {{.Ident}}{&e}
+}
+{{- end}}
+{{- else -}}
+// {{.Ident}} is a vertex of {{.Name}} class.
+type {{.Ident}} struct {
+	*sheikh.Vertex
+}
+{{- if not .Abstract}}
+
+// New{{.Ident}} returns a new {{.Name}} vertex; it has to be inserted with InsertVertex.
+func New{{.Ident}}() {{.Ident}} {
+	v := sheikh.NewVertex(Class{{.Ident}})
+	return {{.Ident}}{&v}
+}
+{{- end}}
+{{- end}}
+{{range .Properties}}
+// {{.Ident}} returns {{.Name}} property.
+func (x {{$cl.Ident}}) {{.Ident}}() ({{.Type.Name}}, error) {
+	return x.{{.Type.Getter}}(Prop{{$cl.Ident}}{{.Ident}})
+}
+
+// Set{{.Ident}} sets {{.Name}} property.
+func (x {{$cl.Ident}}) Set{{.Ident}}(val {{.Type.Name}}) error {
+	return x.SetProps(Prop{{$cl.Ident}}{{.Ident}}, val)
+}
+{{end}}
+// Select{{.Ident}} returns {{.Name}} records, see Connection.Select{{if .Edge}}Edges{{else}}Vertexes{{end}}.
+func Select{{.Ident}}(c *sheikh.Connection, limit int, queryParams string) ([]{{.Ident}}, error) {
+	res, err := c.Select{{if .Edge}}Edges{{else}}Vertexes{{end}}(Class{{.Ident}}, limit, queryParams)
+	var ret []{{.Ident}}
+	for _, entry := range res {
+		ret = append(ret, {{.Ident}}{entry})
+	}
+	return ret, err
+}
+{{range .Properties}}{{if .Indexed}}
+// Select{{$cl.Ident}}By{{.Ident}} returns {{$cl.Name}} records with given {{.Name}}, using its index.
+func Select{{$cl.Ident}}By{{.Ident}}(c *sheikh.Connection, val {{.Type.Name}}, limit int) ([]{{$cl.Ident}}, error) {
+	repr, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	return Select{{$cl.Ident}}(c, limit, "WHERE " + Prop{{$cl.Ident}}{{.Ident}} + " = " + string(repr))
+}
+{{end}}{{end}}{{end}}`))
+
+// generate returns formatted Go source with types for vertex and edge classes from the schema.
+func generate(s *sheikh.Schema, pkg string, only []string) ([]byte, error) {
+	classes, err := collectClasses(s, only)
+	if err != nil {
+		return nil, err
+	}
+	needJson := false // for Select<Class>By<Property> helpers
+	for _, cl := range classes {
+		for _, p := range cl.Properties {
+			needJson = needJson || p.Indexed
+		}
+	}
+	var buff bytes.Buffer
+	err = codeTemplate.Execute(&buff, struct {
+		Package  string
+		NeedJson bool
+		Classes  []genClass
+	}{pkg, needJson, classes})
+	if err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buff.Bytes())
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("generated code is not valid Go syntax: %v", err))
+	}
+	return code, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sheikh"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	s := sheikh.Schema{
+		Classes: []sheikh.Class{
+			{Name: "V"},
+			{Name: "E"},
+			{Name: "OUser"},
+			{Name: "Gopher", SuperClasses: []string{"V"}, Properties: []sheikh.Property{
+				{Name: "name", Type: "STRING"},
+				{Name: "nest_depth", Type: "LONG"},
+				{Name: "tags", Type: "EMBEDDEDLIST"},
+				{Name: "props", Type: "STRING"},
+				{Name: "prop_str", Type: "STRING"},
+			}},
+			{Name: "owes", SuperClasses: []string{"E"}, Properties: []sheikh.Property{
+				{Name: "out", Type: "LINK"},
+				{Name: "amount", Type: "DOUBLE"},
+			}},
+		},
+		Indexes: []sheikh.Index{{Class: "Gopher", Type: "UNIQUE", Fields: []string{"name"}}},
+	}
+	code, err := generate(&s, "models", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []string{
+		"package models",
+		"ClassGopher",
+		"PropGopherNestDepth",
+		"func (x Gopher) NestDepth() (int64, error)",
+		"func (x Gopher) SetTags(val []interface{}) error",
+		"func SelectGopherByName(c *sheikh.Connection, val string, limit int) ([]Gopher, error)",
+		"func NewOwes(from, to *sheikh.Vertex) Owes",
+		"func (x Owes) Amount() (float64, error)",
+		"func (x Gopher) SetPropsProp(val string) error",
+		"func (x Gopher) PropStrProp() (string, error)",
+	} {
+		if !strings.Contains(string(code), expected) {
+			t.Errorf("Generated code doesn't contain %q:\n%s", expected, code)
+		}
+	}
+	for _, unexpected := range []string{"OUser", "PropOwesOut", "SelectOwesByAmount", "SetProps(val", "PropStr()"} {
+		if strings.Contains(string(code), unexpected) {
+			t.Errorf("Generated code contains %q", unexpected)
+		}
+	}
+
+	s.Classes = append(s.Classes, sheikh.Class{Name: "Clash", SuperClasses: []string{"V"}, Properties: []sheikh.Property{
+		{Name: "name", Type: "STRING"},
+		{Name: "set_name", Type: "STRING"},
+	}})
+	if _, err = generate(&s, "models", []string{"Clash"}); err == nil {
+		t.Errorf("generate accepted setter of name clashing with getter of set_name")
+	}
+	if _, err = generate(&s, "models", []string{"Missing"}); err == nil {
+		t.Errorf("generate accepted class missing from the schema")
+	}
+}
@@ -0,0 +1,59 @@
+/* Command sheikh-gen generates Go types wrapping sheikh.Vertex and sheikh.Edge for vertex and edge classes of an
+OrientDB database, with constants for class and property names, typed getters and setters, and query helpers.
+Misspelled property names then fail at compile time instead of at runtime. Usage:
+   sheikh-gen -db GratefulDeadConcerts -user admin -pass admin -pkg models -out models/schema_gen.go */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sheikh"
+	"strings"
+)
+
+func main() {
+	server := flag.String("server", "localhost", "OrientDB server address")
+	port := flag.String("port", "2480", "OrientDB HTTP port")
+	db := flag.String("db", "", "database name")
+	user := flag.String("user", "admin", "database user")
+	pass := flag.String("pass", "admin", "database password")
+	pkg := flag.String("pkg", "models", "name of the generated package")
+	out := flag.String("out", "", "output file (standard output if empty)")
+	classes := flag.String("classes", "", "comma-separated classes to generate (all vertex and edge classes if empty)")
+	flag.Parse()
+	if *db == "" {
+		fmt.Fprintln(os.Stderr, "sheikh-gen: -db is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := sheikh.NewConnection(*server, *db, *user, *pass)
+	c.Port = *port
+	if err := c.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "sheikh-gen: %v\n", err)
+		os.Exit(1)
+	}
+	schema, err := c.Schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sheikh-gen: %v\n", err)
+		os.Exit(1)
+	}
+	var only []string
+	if *classes != "" {
+		only = strings.Split(*classes, ",")
+	}
+	code, err := generate(schema, *pkg, only)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sheikh-gen: %v\n", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err = os.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "sheikh-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+/* Command sheikh-infer samples records of schemaless vertex and edge classes and proposes schema for them, either as
+JSON in the format read by sheikh.LoadSchema or as SQL statements bringing the database to it. Usage:
+   sheikh-infer -db GratefulDeadConcerts -classes V,followed_by -sample 1000 -format sql */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sheikh"
+	"sort"
+	"strings"
+)
+
+func main() {
+	server := flag.String("server", "localhost", "OrientDB server address")
+	port := flag.String("port", "2480", "OrientDB HTTP port")
+	db := flag.String("db", "", "database name")
+	user := flag.String("user", "admin", "database user")
+	pass := flag.String("pass", "admin", "database password")
+	classes := flag.String("classes", "", "comma-separated classes to sample (all vertex and edge classes if empty)")
+	sample := flag.Int("sample", 1000, "maximum number of records sampled from each class, the first ones (0 for all)")
+	outFormat := flag.String("format", "json", "output format: json (declarative schema) or sql (statements)")
+	stats := flag.Bool("stats", false, "print statistics of sampled properties to standard error")
+	flag.Parse()
+	if *db == "" || (*outFormat != "json" && *outFormat != "sql") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := sheikh.NewConnection(*server, *db, *user, *pass)
+	c.Port = *port
+	if err := c.Connect(); err != nil {
+		fail(err)
+	}
+	var names []string
+	if *classes != "" {
+		names = strings.Split(*classes, ",")
+	} else {
+		current, err := c.Schema()
+		if err != nil {
+			fail(err)
+		}
+		for _, cl := range current.Classes {
+			if cl.Name != "V" && cl.Name != "E" && (current.IsA(cl.Name, "V") || current.IsA(cl.Name, "E")) {
+				names = append(names, cl.Name)
+			}
+		}
+		sort.Strings(names)
+	}
+	proposal, allStats, err := c.InferSchema(*sample, names...)
+	if err != nil {
+		fail(err)
+	}
+	if *stats {
+		for _, cs := range allStats {
+			printStats(cs)
+		}
+	}
+	if *outFormat == "sql" {
+		plan, err := proposal.Plan(&c)
+		if err != nil {
+			fail(err)
+		}
+		for _, comText := range plan {
+			fmt.Println(comText + ";")
+		}
+		return
+	}
+	out, err := json.MarshalIndent(proposal, "", "  ")
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(string(out))
+}
+
+func printStats(cs *sheikh.ClassStats) {
+	fmt.Fprintf(os.Stderr, "%s: %v records sampled", cs.Class, cs.Sampled)
+	if cs.Complete {
+		fmt.Fprint(os.Stderr, " (all)")
+	}
+	fmt.Fprintln(os.Stderr)
+	for _, ps := range cs.Properties {
+		var types []string
+		for odbType, count := range ps.Types {
+			types = append(types, fmt.Sprintf("%s×%v", odbType, count))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(os.Stderr, "  %s: present %v, null %v, distinct %v, types %s", ps.Name, ps.Present, ps.Nulls,
+			ps.Distinct, strings.Join(types, " "))
+		if ps.HasRange {
+			fmt.Fprintf(os.Stderr, ", range %v..%v", ps.Min, ps.Max)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "sheikh-infer: %v\n", err)
+	os.Exit(1)
+}
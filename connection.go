@@ -1,30 +1,40 @@
 package sheikh
 
 import (
-	"chillson"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
+	"container/list"
 	"net/http"
 	"net/http/cookiejar"
-	"net/url"
-	"strings"
 	"time"
 )
 
 type Connection struct {
+	// Port is the one of REST API; with binary protocol, it's used only by server administration methods.
 	Server, Database, Port string
 	Username, Password     string
-	Client                 http.Client
+	// Credentials of server user (e.g. root), used by server administration methods like CreateDatabase.
+	ServerUsername, ServerPassword string
+	// HTTP client used by RESTTransport and server administration methods.
+	Client http.Client
+	// Carries commands to the database; RESTTransport is used if it's nil.
+	Transport Transport
+	// Maximum number of vertexes and edges kept in the index, least recently used are dropped first; 0 is no limit.
+	CacheSize int
+	// Number of times a request is retried when connecting to the server fails.
+	Retries int
 	// Index of vertexes and edges received from the db (indexed by RIDs).
 	vertexes map[string](*Vertex)
 	edges    map[string](*Edge)
+	// RIDs of indexed vertexes and edges, the most recently used first (kept only when CacheSize is set).
+	cacheOrder *list.List
+	cacheElems map[string]*list.Element
+	// Schema used to validate records before sending them (see EnableValidation).
+	validation *Schema
 }
 
 /* NewConnection returns Connection object, which should be initialized with Connect() method before
-being used. You have to change the port manually if you wish to:
+being used. It uses port 2480 and a 2-second timeout, which can be changed directly, e.g.
    c.Port = "8080"
+or configured with a URL passed to Open instead.
 
 For example,
    c := NewConnection("localhost", "GratefulDeadConcerts", "admin", "admin")
@@ -42,96 +52,70 @@ func NewConnection(servAddr, dbName, user, pass string) (c Connection) {
 
 	c.Client.Jar, _ = cookiejar.New(nil)
 	c.Client.Timeout = 2 * time.Second
+	c.Transport = &RESTTransport{}
 	return
 }
 
-type respAndError struct {
-	resp *http.Response
-	err  error
-}
-
-// doRequest spawns a goroutine, which should do a request, and handles timeout.
-func (c *Connection) doRequest(req *http.Request) (*http.Response, error) {
-	requestDone := make(chan respAndError)
-	go func() {
-		resp, err := (*c).Client.Do(req)
-		requestDone <- respAndError{resp, err}
-		return
-	}()
-	var result respAndError
-	result = <-requestDone
-	return result.resp, result.err
-}
-
 /* Command is a low-level method that performs OrientDB SQL command given in the argument. It returns ["result"] array from JSON
 response from the server, which should contain records returned by the database convertable, to map[string]interface{}. First database
 error encountered is copied to the error message of the method. */
 func (c *Connection) Command(text string) ([]interface{}, error) {
-	text = url.QueryEscape(text)
-	addr := fmt.Sprintf("http://%s:%s/command/%s/sql/%s", (*c).Server, (*c).Port, (*c).Database, text)
-	req, err := http.NewRequest("POST", addr, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Accept-Encoding", "gzip,deflate")
-	req.Header.Set("Content-Length", "0")
+	return c.transport().Command(c, text)
+}
 
-	retriedAuth := false
-RetryCommand:
-	resp, err := (*c).doRequest(req)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode == http.StatusUnauthorized && !retriedAuth {
-		(*c).Connect()
-		retriedAuth = true
-		goto RetryCommand
-	}
-	buff := make([]byte, 10240)
-	p, err := io.ReadFull(resp.Body, buff)
-	if err != io.ErrUnexpectedEOF {
-		resp.Body.Close()
-		return nil, err
-	}
-	//TODO: how to act when buffer exceeded
-	resp.Body.Close()
-	buff = buff[:p]
+/* Batch performs SQL script, given as a list of statements, in one transaction. Result of the last statement (or of
+RETURN statement) is returned as in Command, e.g.
+   c.Batch("LET v = CREATE VERTEX Gopher", "CREATE EDGE owes FROM $v TO #9:1", "RETURN $v")
+Schema can't be changed in a transaction; use Command for that, e.g. in migrations. */
+func (c *Connection) Batch(script ...string) ([]interface{}, error) {
+	return c.batch(true, script)
+}
 
-	var respJson interface{}
-	json.Unmarshal(buff, &respJson)
-	chill := chillson.Son{respJson}
-	firstErr, err := chill.GetObj("[errors][0]")
-	if err == nil {
-		chill = chillson.Son{firstErr} // extract from ['errors'][0]
-		reason, _ := chill.GetStr("[reason]")
-		content, _ := chill.GetStr("[content]")
-		return nil, errors.New(fmt.Sprintf("Command %v failed, server error reason: %v; content: %q", text, reason, content))
-	}
-	result, err := chill.GetArr("[result]")
-	if err == nil {
-		return result, nil
-	}
-	return nil, errors.New(fmt.Sprintf("Unable to extract result from server response to command %v, response body: %v", text, respJson))
+// batch sends SQL script to the server; schema changes can be only made in scripts run without transaction.
+func (c *Connection) batch(transaction bool, script []string) ([]interface{}, error) {
+	return c.transport().Batch(c, transaction, script)
 }
 
 /* Connect method tries to connect to the OrientDB server and perform authorization. */
 func (c *Connection) Connect() error {
-	addr := fmt.Sprintf("http://%s:%s/connect/%s", (*c).Server, (*c).Port, (*c).Database)
-	req, err := http.NewRequest("GET", addr, nil)
-	if err != nil {
-		return err
-	}
-	req.SetBasicAuth((*c).Username, (*c).Password)
+	return c.transport().Authenticate(c)
+}
 
-	resp, err := (*c).doRequest(req)
-	if err != nil {
-		return err
+/* touch marks the indexed vertex or edge as used and, when CacheSize is exceeded, drops the least recently used ones
+from the index. It's called on every lookup of an indexed record. Vertexes and edges refer to each other by RIDs, so
+ones referring to a dropped record just fetch it again when needed (e.g. in From or To). */
+func (c *Connection) touch(rid string) {
+	if (*c).CacheSize <= 0 || ((*c).vertexes[rid] == nil && (*c).edges[rid] == nil) {
+		return
+	}
+	if (*c).cacheOrder == nil {
+		(*c).cacheOrder, (*c).cacheElems = list.New(), make(map[string]*list.Element)
+	}
+	if elem, present := (*c).cacheElems[rid]; present {
+		(*c).cacheOrder.MoveToFront(elem)
+	} else {
+		(*c).cacheElems[rid] = (*c).cacheOrder.PushFront(rid)
 	}
-	if resp.StatusCode != 204 {
-		return errors.New(fmt.Sprintf("Connecting to OrientDB: HTTP status %v, perhaps wrong credentials", resp.Status))
+	for (*c).cacheOrder.Len() > (*c).CacheSize {
+		oldest := (*c).cacheOrder.Remove((*c).cacheOrder.Back()).(string)
+		delete((*c).cacheElems, oldest)
+		delete((*c).vertexes, oldest)
+		delete((*c).edges, oldest)
 	}
-	if cookies := (*c).Client.Jar.Cookies(req.URL); len(cookies) != 1 || strings.Index(cookies[0].String(), "OSESSIONID=") == -1 {
-		return errors.New("Connecting to OrientDB: connection ok, but OSESSIONID cookie not present in server response, wrong address?")
+}
+
+// untouch stops tracking use of the vertex or edge removed from the index.
+func (c *Connection) untouch(rid string) {
+	if elem, present := (*c).cacheElems[rid]; present {
+		(*c).cacheOrder.Remove(elem)
+		delete((*c).cacheElems, rid)
+	}
+}
+
+// transport returns Transport of the connection, which is REST API if none was set.
+func (c *Connection) transport() Transport {
+	if (*c).Transport == nil {
+		(*c).Transport = &RESTTransport{}
 	}
-	return err // nil if all OK
+	return (*c).Transport
 }
@@ -0,0 +1,393 @@
+package sheikh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* This file implements CSV record serialization (ORecordDocument2csv) used by the binary protocol. Records are
+decoded to the same values as returned by the REST API: links are RID strings, dates are formatted strings and
+embedded records are maps with @type and @class. */
+
+/* MarshalRecordCSV serializes fields of a record of the class in CSV format, e.g.
+   Gopher@name:"Bob",age:3,friend:#9:1
+Strings looking like RIDs are written as links, and maps with @type or @class as embedded records. Fields starting
+with @ are skipped. */
+func MarshalRecordCSV(class string, fields map[string]interface{}) []byte {
+	var buff bytes.Buffer
+	if class != "" {
+		buff.WriteString(class + "@")
+	}
+	writeCSVFields(&buff, fields)
+	return buff.Bytes()
+}
+
+func writeCSVFields(buff *bytes.Buffer, fields map[string]interface{}) {
+	var names []string
+	for name := range fields {
+		if !strings.HasPrefix(name, "@") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for ind, name := range names {
+		if ind != 0 {
+			buff.WriteByte(',')
+		}
+		buff.WriteString(name + ":")
+		writeCSVValue(buff, fields[name])
+	}
+}
+
+func writeCSVString(buff *bytes.Buffer, str string) {
+	buff.WriteByte('"')
+	buff.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(str))
+	buff.WriteByte('"')
+}
+
+func writeCSVValue(buff *bytes.Buffer, val interface{}) {
+	switch t := val.(type) {
+	case nil:
+	case bool:
+		buff.WriteString(strconv.FormatBool(t))
+	case string:
+		if ridPattern.MatchString(t) {
+			buff.WriteString(t)
+		} else {
+			writeCSVString(buff, t)
+		}
+	case float64:
+		switch {
+		case t == math.Trunc(t) && t >= math.MinInt32 && t <= math.MaxInt32:
+			buff.WriteString(strconv.FormatInt(int64(t), 10))
+		case t == math.Trunc(t) && math.Abs(t) <= maxExactFloat:
+			buff.WriteString(strconv.FormatInt(int64(t), 10) + "l")
+		default:
+			buff.WriteString(strconv.FormatFloat(t, 'g', -1, 64) + "d")
+		}
+	case float32:
+		buff.WriteString(strconv.FormatFloat(float64(t), 'g', -1, 32) + "f")
+	case int:
+		writeCSVValue(buff, int64(t))
+	case int32:
+		buff.WriteString(strconv.FormatInt(int64(t), 10))
+	case int64:
+		if t >= math.MinInt32 && t <= math.MaxInt32 {
+			buff.WriteString(strconv.FormatInt(t, 10))
+		} else {
+			buff.WriteString(strconv.FormatInt(t, 10) + "l")
+		}
+	case uint64:
+		buff.WriteString(strconv.FormatUint(t, 10) + "l")
+	case []interface{}:
+		buff.WriteByte('[')
+		for ind, elem := range t {
+			if ind != 0 {
+				buff.WriteByte(',')
+			}
+			writeCSVValue(buff, elem)
+		}
+		buff.WriteByte(']')
+	case map[string]interface{}:
+		_, typed := t["@type"]
+		class, classed := t["@class"].(string)
+		if typed || classed {
+			buff.WriteByte('(')
+			if classed {
+				buff.WriteString(class + "@")
+			}
+			writeCSVFields(buff, t)
+			buff.WriteByte(')')
+			return
+		}
+		var keys []string
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buff.WriteByte('{')
+		for ind, key := range keys {
+			if ind != 0 {
+				buff.WriteByte(',')
+			}
+			writeCSVString(buff, key)
+			buff.WriteByte(':')
+			writeCSVValue(buff, t[key])
+		}
+		buff.WriteByte('}')
+	case []byte:
+		buff.WriteString("_" + base64.StdEncoding.EncodeToString(t) + "_")
+	default: // e.g. json.Number or other numeric types
+		buff.WriteString(fmt.Sprint(t))
+	}
+}
+
+/* UnmarshalRecordCSV parses record serialized in CSV format, returning its class (empty if it wasn't given) and
+fields. */
+func UnmarshalRecordCSV(data []byte) (string, map[string]interface{}, error) {
+	p := &csvParser{data: data}
+	class, fields, err := p.record(0)
+	if err == nil && p.pos != len(data) {
+		err = p.fail("end of record")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return class, fields, nil
+}
+
+// csvParser reads CSV-serialized record from data, starting at pos.
+type csvParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *csvParser) fail(expected string) error {
+	found := "end of record"
+	if p.pos < len(p.data) {
+		found = fmt.Sprintf("%q", p.data[p.pos])
+	}
+	return errors.New(fmt.Sprintf("Parsing CSV record: expected %s, found %s at %v in %q", expected, found, p.pos,
+		p.data))
+}
+
+func (p *csvParser) peek() byte {
+	if p.pos < len(p.data) {
+		return p.data[p.pos]
+	}
+	return 0
+}
+
+// record parses fields up to the end byte (0 for the end of data), along with optional Class@ prefix.
+func (p *csvParser) record(end byte) (string, map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	var class string
+	for ind := p.pos; ind < len(p.data) && p.data[ind] != ':' && p.data[ind] != ',' && p.data[ind] != end; ind++ {
+		if p.data[ind] == '@' {
+			class = string(p.data[p.pos:ind])
+			p.pos = ind + 1
+			break
+		}
+	}
+	for p.pos < len(p.data) && p.peek() != end {
+		if len(fields) != 0 {
+			if p.peek() != ',' {
+				return "", nil, p.fail("','")
+			}
+			p.pos++
+		}
+		colon := bytes.IndexByte(p.data[p.pos:], ':')
+		if colon == -1 {
+			return "", nil, p.fail("':'")
+		}
+		name := strings.Trim(string(p.data[p.pos:p.pos+colon]), `"`)
+		p.pos += colon + 1
+		val, err := p.value()
+		if err != nil {
+			return "", nil, err
+		}
+		fields[name] = val
+	}
+	return class, fields, nil
+}
+
+// isCSVDelimiter tells whether the byte ends a scalar value.
+func isCSVDelimiter(b byte) bool {
+	return b == ',' || b == ')' || b == ']' || b == '>' || b == '}' || b == ':'
+}
+
+func (p *csvParser) value() (interface{}, error) {
+	if p.pos == len(p.data) || isCSVDelimiter(p.peek()) {
+		return nil, nil
+	}
+	switch p.peek() {
+	case '"':
+		return p.str()
+	case '[', '<':
+		end := map[byte]byte{'[': ']', '<': '>'}[p.peek()]
+		p.pos++
+		ret := []interface{}{}
+		for p.peek() != end {
+			if len(ret) != 0 {
+				if p.peek() != ',' {
+					return nil, p.fail("','")
+				}
+				p.pos++
+			}
+			elem, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, elem)
+			if p.pos >= len(p.data) {
+				return nil, p.fail(string(end))
+			}
+		}
+		p.pos++
+		return ret, nil
+	case '{':
+		p.pos++
+		ret := make(map[string]interface{})
+		for p.peek() != '}' {
+			if len(ret) != 0 {
+				if p.peek() != ',' {
+					return nil, p.fail("','")
+				}
+				p.pos++
+			}
+			var key interface{}
+			var err error
+			if p.peek() == '"' {
+				key, err = p.str()
+			} else {
+				key, err = p.value()
+			}
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() != ':' {
+				return nil, p.fail("':'")
+			}
+			p.pos++
+			val, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			ret[fmt.Sprint(key)] = val
+		}
+		p.pos++
+		return ret, nil
+	case '(':
+		p.pos++
+		class, fields, err := p.record(')')
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.fail("')'")
+		}
+		p.pos++
+		fields["@type"] = "d"
+		if class != "" {
+			fields["@class"] = class
+		}
+		return fields, nil
+	case '%':
+		end := bytes.IndexByte(p.data[p.pos:], ';')
+		if end == -1 {
+			return nil, p.fail("';'")
+		}
+		encoded := string(p.data[p.pos+1 : p.pos+end])
+		p.pos += end + 1
+		return decodeRidBag(encoded)
+	case '_':
+		end := bytes.IndexByte(p.data[p.pos+1:], '_')
+		if end == -1 {
+			return nil, p.fail("'_'")
+		}
+		encoded := string(p.data[p.pos+1 : p.pos+1+end])
+		p.pos += end + 2
+		return encoded, nil // REST API returns binary data in base64 too
+	}
+	start := p.pos
+	for p.pos < len(p.data) && (!isCSVDelimiter(p.peek()) || p.peek() == ':' && p.data[start] == '#') {
+		p.pos++
+	}
+	return csvScalar(string(p.data[start:p.pos]))
+}
+
+func (p *csvParser) str() (string, error) {
+	var buff bytes.Buffer
+	for p.pos++; p.pos < len(p.data); p.pos++ {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos++
+			if p.pos < len(p.data) {
+				buff.WriteByte(p.data[p.pos])
+			}
+		case '"':
+			p.pos++
+			return buff.String(), nil
+		default:
+			buff.WriteByte(p.data[p.pos])
+		}
+	}
+	return "", p.fail(`'"'`)
+}
+
+// csvScalar decodes unquoted value: link, boolean or number with optional type suffix.
+func csvScalar(token string) (interface{}, error) {
+	switch {
+	case token == "true" || token == "false":
+		return token == "true", nil
+	case strings.HasPrefix(token, "#"):
+		return token, nil
+	}
+	num, suffix := token, byte(0)
+	if last := token[len(token)-1]; strings.IndexByte("lsbfdcat", last) != -1 {
+		num, suffix = token[:len(token)-1], last
+	}
+	switch suffix {
+	case 'a', 't':
+		millis, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Parsing CSV record: bad date %q", token))
+		}
+		date := time.Unix(millis/1000, millis%1000*int64(time.Millisecond))
+		if suffix == 'a' {
+			return date.Format("2006-01-02"), nil
+		}
+		return date.Format("2006-01-02 15:04:05"), nil
+	case 'f', 'd', 'c':
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Parsing CSV record: bad number %q", token))
+		}
+		return f, nil
+	}
+	if _, err := strconv.ParseFloat(num, 64); err != nil {
+		return nil, errors.New(fmt.Sprintf("Parsing CSV record: unexpected value %q", token))
+	}
+	return normalizeNumbers(json.Number(num)), nil
+}
+
+/* decodeRidBag decodes embedded RidBag (used for out_* and in_* fields of vertexes) to a list of RIDs. Tree-based
+bags, stored apart from the record, aren't supported. */
+func decodeRidBag(encoded string) ([]interface{}, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Parsing CSV record: bad RidBag %q", encoded))
+	}
+	if len(raw) < 5 || raw[0]&1 == 0 {
+		return nil, errors.New("Parsing CSV record: tree-based RidBags are not supported")
+	}
+	offset := 1
+	if raw[0]&2 != 0 { // UUID of the bag
+		offset += 16
+	}
+	if len(raw) < offset+4 {
+		return nil, errors.New("Parsing CSV record: truncated RidBag")
+	}
+	size := int(int32(binary.BigEndian.Uint32(raw[offset:])))
+	offset += 4
+	ret := []interface{}{}
+	for ind := 0; ind < size; ind++ {
+		if len(raw) < offset+10 {
+			return nil, errors.New("Parsing CSV record: truncated RidBag")
+		}
+		cluster := int16(binary.BigEndian.Uint16(raw[offset:]))
+		position := int64(binary.BigEndian.Uint64(raw[offset+2:]))
+		ret = append(ret, fmt.Sprintf("#%v:%v", cluster, position))
+		offset += 10
+	}
+	return ret, nil
+}
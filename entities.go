@@ -4,6 +4,9 @@ import (
 	"chillson"
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"strings"
 )
 
 /*type Entity interface {
@@ -45,6 +48,13 @@ type Doc struct {
 	diff           []string // changes since the last update to/from database
 	propsContainer map[string]interface{}
 	props          chillson.Son
+	synced         map[string]interface{} // copy of properties as of the last update to/from database
+}
+
+// Change describes a pending modification of a property: its last synced and current value.
+type Change struct {
+	Old, New interface{}
+	Removed  bool // property (or path) is not present locally anymore
 }
 
 type vtxRel struct {
@@ -62,6 +72,7 @@ type Vertex struct {
 type Edge struct {
 	Entry  Doc
 	vertex map[EdgeDirection]string
+	moved  map[EdgeDirection]string // original ends of the edge changed with SetFrom/SetTo, by direction
 }
 
 func docInit(d *Doc) {
@@ -69,6 +80,45 @@ func docInit(d *Doc) {
 	d.props = chillson.Son{d.propsContainer}
 }
 
+// markSynced remembers current properties as the state stored in the database.
+func markSynced(d *Doc) {
+	d.synced, _ = deepCopy(d.propsContainer).(map[string]interface{})
+}
+
+// IsDirty tells whether the object has local changes which weren't sent to the database.
+func (d *Doc) IsDirty() bool {
+	return len(d.diff) != 0
+}
+
+/* Changes returns pending modifications of the object, indexed by property labels (or dotted paths, if they were set
+with SetPath). Old values are nil for properties which weren't present in the database. */
+func (d *Doc) Changes() map[string]Change {
+	ret := make(map[string]Change)
+	for _, label := range d.diff {
+		oldVal, _ := lookupPath(d.synced, label)
+		newVal, present := lookupPath(d.propsContainer, label)
+		ret[label] = Change{deepCopy(oldVal), deepCopy(newVal), !present}
+	}
+	return ret
+}
+
+/* Revert discards local changes, restoring properties to the state of the last update to/from database. For objects
+which weren't uploaded to the database yet, it removes all properties. */
+func (d *Doc) Revert() {
+	d.propsContainer, _ = deepCopy(d.synced).(map[string]interface{})
+	if d.propsContainer == nil {
+		d.propsContainer = make(map[string]interface{})
+	}
+	d.props = chillson.Son{d.propsContainer}
+	d.diff = nil
+}
+
+// Snapshot returns a deep copy of current properties of the object.
+func (d *Doc) Snapshot() map[string]interface{} {
+	ret, _ := deepCopy(d.propsContainer).(map[string]interface{})
+	return ret
+}
+
 /* CreateEdge returns an Edge object representing relation between two vertexes of given class; edge must
 be inserted to the database before it will be accesible from vertexes' Edges method. */
 func CreateEdge(from *Vertex, className string, to *Vertex) (e Edge) {
@@ -84,6 +134,7 @@ the edge. Edges by end user should be rather by creating relation between Vertex
 func newEdge() (e Edge) {
 	docInit(&e.Entry)
 	e.vertex = make(map[EdgeDirection]string)
+	e.moved = make(map[EdgeDirection]string)
 	return
 }
 
@@ -143,6 +194,35 @@ func (e Edge) PropRequireInt(name string) int {
 	return e.Entry.props.RequireInt("[" + name + "]")
 }
 
+// PropInt64 extracts edge's property as int64 (provided that it is defined for the Edge and fits in int64).
+func (e Edge) PropInt64(name string) (int64, error) {
+	return propInt64(e.Entry.props, name)
+}
+
+// PropRequireInt64 works like PropInt64, but panics when the property is missing, fractional or overflows int64.
+func (e Edge) PropRequireInt64(name string) int64 {
+	val, err := propInt64(e.Entry.props, name)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
+// PropUint64 extracts edge's property as uint64 (provided that it is defined for the Edge and fits in uint64).
+func (e Edge) PropUint64(name string) (uint64, error) {
+	return propUint64(e.Entry.props, name)
+}
+
+/* PropRequireUint64 works like PropUint64, but panics when the property is missing, fractional, negative or
+overflows uint64. */
+func (e Edge) PropRequireUint64(name string) uint64 {
+	val, err := propUint64(e.Entry.props, name)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
 // PropObj extracts edge's property as an object (Go type map[string]interface{}) (provided that it is defined for the Edge).
 func (e Edge) PropObj(name string) (map[string]interface{}, error) {
 	return e.Entry.props.GetObj("[" + name + "]")
@@ -206,6 +286,35 @@ func (v Vertex) PropRequireInt(name string) int {
 	return v.Entry.props.RequireInt("[" + name + "]")
 }
 
+// PropInt64 extracts vertex' property as int64 (provided that it is defined for the Vertex and fits in int64).
+func (v Vertex) PropInt64(name string) (int64, error) {
+	return propInt64(v.Entry.props, name)
+}
+
+// PropRequireInt64 works like PropInt64, but panics when the property is missing, fractional or overflows int64.
+func (v Vertex) PropRequireInt64(name string) int64 {
+	val, err := propInt64(v.Entry.props, name)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
+// PropUint64 extracts vertex' property as uint64 (provided that it is defined for the Vertex and fits in uint64).
+func (v Vertex) PropUint64(name string) (uint64, error) {
+	return propUint64(v.Entry.props, name)
+}
+
+/* PropRequireUint64 works like PropUint64, but panics when the property is missing, fractional, negative or
+overflows uint64. */
+func (v Vertex) PropRequireUint64(name string) uint64 {
+	val, err := propUint64(v.Entry.props, name)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
 // PropObj extracts vertex' property as an object (Go type map[string]interface{}) (provided that it is defined for the Vertex).
 func (v Vertex) PropObj(name string) (map[string]interface{}, error) {
 	return v.Entry.props.GetObj("[" + name + "]")
@@ -224,6 +333,63 @@ func (v Vertex) PropRequireStr(name string) string {
 	return v.Entry.props.RequireStr("[" + name + "]")
 }
 
+/* propInt64 reads a numeric property without going through float64, so longs decoded from the server (or set
+locally) keep their precision. It fails instead of truncating when the value is fractional or out of range. */
+func propInt64(props chillson.Son, name string) (int64, error) {
+	val, err := props.Get("[" + name + "]")
+	if err != nil {
+		return 0, err
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() <= math.MaxInt64 {
+			return int64(rv.Uint()), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) {
+			return 0, errors.New(fmt.Sprintf("Property %s: %v is not an integer", name, val))
+		}
+		if f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), nil
+		}
+	default:
+		return 0, errors.New(fmt.Sprintf("Property %s: %v is not a number", name, val))
+	}
+	return 0, errors.New(fmt.Sprintf("Property %s: %v overflows int64", name, val))
+}
+
+// propUint64 works like propInt64, but for unsigned values.
+func propUint64(props chillson.Son, name string) (uint64, error) {
+	val, err := props.Get("[" + name + "]")
+	if err != nil {
+		return 0, err
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() >= 0 {
+			return uint64(rv.Int()), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) {
+			return 0, errors.New(fmt.Sprintf("Property %s: %v is not an integer", name, val))
+		}
+		if f >= 0 && f < math.MaxUint64 {
+			return uint64(f), nil
+		}
+	default:
+		return 0, errors.New(fmt.Sprintf("Property %s: %v is not a number", name, val))
+	}
+	return 0, errors.New(fmt.Sprintf("Property %s: %v overflows uint64", name, val))
+}
+
 func setProps(container *map[string]interface{}, diff *[]string, a []interface{}) error {
 	if len(a) == 0 || len(a)%2 != 0 {
 		return errors.New("SetProp: no arguments or odd number of arguments")
@@ -235,7 +401,91 @@ func setProps(container *map[string]interface{}, diff *[]string, a []interface{}
 		}
 		val, _ := a[i+1].(interface{})
 		(*container)[label] = val
-		(*diff) = append(*diff, label)
+		markChanged(diff, label)
+	}
+	return nil
+}
+
+/* markChanged adds label (which can be a dotted path) to the diff list, unless the same label or its parent is
+already there. Paths nested in label are dropped, as they will be sent as a part of the whole value. */
+func markChanged(diff *[]string, label string) {
+	for _, changed := range *diff {
+		if changed == label || strings.HasPrefix(label, changed+".") {
+			return
+		}
+	}
+	var kept []string
+	for _, changed := range *diff {
+		if !strings.HasPrefix(changed, label+".") {
+			kept = append(kept, changed)
+		}
+	}
+	*diff = append(kept, label)
+}
+
+// lookupPath finds value under dotted path (e.g. "address.city") in embedded maps of the container.
+func lookupPath(container map[string]interface{}, path string) (interface{}, bool) {
+	if val, present := container[path]; present { // label set verbatim with SetProps
+		return val, true
+	}
+	steps := strings.Split(path, ".")
+	for _, step := range steps[:len(steps)-1] {
+		var ok bool
+		if container, ok = container[step].(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	val, present := container[steps[len(steps)-1]]
+	return val, present
+}
+
+/* parentMap returns the embedded map holding the last element of dotted path, along with its key. Missing maps are
+created when create is true; path of the outermost one created is returned too (empty if there's none). */
+func parentMap(container map[string]interface{}, path string, create bool) (map[string]interface{}, string, string, error) {
+	steps := strings.Split(path, ".")
+	created := ""
+	for ind, step := range steps[:len(steps)-1] {
+		val, present := container[step]
+		if !present && create {
+			val = make(map[string]interface{})
+			container[step] = val
+			if created == "" {
+				created = strings.Join(steps[:ind+1], ".")
+			}
+		}
+		next, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, "", "", errors.New(fmt.Sprintf("Property path %s: %s is not an embedded map",
+				path, strings.Join(steps[:ind+1], ".")))
+		}
+		container = next
+	}
+	return container, steps[len(steps)-1], created, nil
+}
+
+/* setPath assigns value under dotted path. When maps on the path were missing, the outermost created map is marked
+as changed, so that it's sent to the database as a whole. */
+func setPath(container map[string]interface{}, diff *[]string, path string, val interface{}) error {
+	parent, key, created, err := parentMap(container, path, true)
+	if err != nil {
+		return err
+	}
+	parent[key] = val
+	if created != "" {
+		path = created
+	}
+	markChanged(diff, path)
+	return nil
+}
+
+func removeProps(container map[string]interface{}, diff *[]string, names []string) error {
+	for _, name := range names {
+		parent, key, _, err := parentMap(container, name, false)
+		if err != nil {
+			return err
+		}
+		delete(parent, key)
+		markChanged(diff, name)
 	}
 	return nil
 }
@@ -243,7 +493,8 @@ func setProps(container *map[string]interface{}, diff *[]string, a []interface{}
 /* SetProp takes an arbitrary number of property labels followed by their values. E.g.
 SetProp("foo", "bar",  "baz", 5) assigns "bar" to "foo" property and 5 to "baz" property.
 Method performs assignment in given order, and terminates if property label is not a string.
-Arguments are not checked against schema constraints, which is left to the database. */
+Arguments are not checked against schema constraints, which is left to the database, unless validation is enabled
+with Connection.EnableValidation. */
 func (e *Edge) SetProps(a ...interface{}) error {
 	return setProps(&e.Entry.propsContainer, &e.Entry.diff, a)
 }
@@ -251,39 +502,97 @@ func (e *Edge) SetProps(a ...interface{}) error {
 /* SetProp takes a arbitrary number of property labels followed by their values. E.g.
 SetProp("foo", "bar",  "baz", 5) assigns "bar" to "foo" property and 5 to "baz" property.
 Method performs assignment in given order, and terminates if property label is not a string.
-Arguments are not checked against schema constraints, which is left to the database. */
+Arguments are not checked against schema constraints, which is left to the database, unless validation is enabled
+with Connection.EnableValidation. */
 func (v *Vertex) SetProps(a ...interface{}) error {
 	return setProps(&v.Entry.propsContainer, &v.Entry.diff, a)
 }
 
+/* SetPath assigns value to a property of embedded map, given as a dotted path, e.g. SetPath("address.city", "Oslo").
+Missing maps on the path are created; only the changed field (or the outermost created map) is sent to the database
+on UpdateEdge. */
+func (e *Edge) SetPath(path string, val interface{}) error {
+	return setPath(e.Entry.propsContainer, &e.Entry.diff, path, val)
+}
+
+/* SetPath assigns value to a property of embedded map, given as a dotted path, e.g. SetPath("address.city", "Oslo").
+Missing maps on the path are created; only the changed field (or the outermost created map) is sent to the database
+on UpdateVertex. */
+func (v *Vertex) SetPath(path string, val interface{}) error {
+	return setPath(v.Entry.propsContainer, &v.Entry.diff, path, val)
+}
+
+/* RemoveProps deletes properties (or dotted paths inside embedded maps) from the edge. They will be removed from the
+database on UpdateEdge. */
+func (e *Edge) RemoveProps(names ...string) error {
+	return removeProps(e.Entry.propsContainer, &e.Entry.diff, names)
+}
+
+/* RemoveProps deletes properties (or dotted paths inside embedded maps) from the vertex. They will be removed from the
+database on UpdateVertex. */
+func (v *Vertex) RemoveProps(names ...string) error {
+	return removeProps(v.Entry.propsContainer, &v.Entry.diff, names)
+}
+
+func (e *Edge) setEnd(dirn EdgeDirection, v *Vertex) {
+	if e.Entry.Rid == "" { // not in the database yet, nothing to track
+		e.vertex[dirn] = v.Entry.Rid
+		return
+	}
+	orig, moved := e.moved[dirn]
+	if !moved {
+		orig = e.vertex[dirn]
+	}
+	e.vertex[dirn] = v.Entry.Rid
+	if orig == v.Entry.Rid {
+		delete(e.moved, dirn)
+	} else {
+		e.moved[dirn] = orig
+	}
+}
+
+/* SetFrom makes the Edge start at given Vertex. For edges already stored in the database, the change is applied by
+UpdateEdge, which also fixes relations of the old and new vertex. */
+func (e *Edge) SetFrom(v *Vertex) {
+	e.setEnd(Out, v)
+}
+
+/* SetTo makes the Edge end at given Vertex. For edges already stored in the database, the change is applied by
+UpdateEdge, which also fixes relations of the old and new vertex. */
+func (e *Edge) SetTo(v *Vertex) {
+	e.setEnd(In, v)
+}
+
 /* From returns Vertex when the Edge starts ("out" Vertex). */
 func (e *Edge) From(c *Connection) (*Vertex, error) {
 	if (*c).vertexes[e.vertex[Out]] != nil {
+		c.touch(e.vertex[Out])
 		return (*c).vertexes[e.vertex[Out]], nil
 	}
-	vs, err := (*c).SelectVertexes(e.vertex[Out], 1, "")
+	raw, err := c.transport().Document(c, e.vertex[Out])
 	if err != nil {
 		return nil, err
 	}
-	if len(vs) != 1 {
+	if raw == nil {
 		return nil, errors.New(fmt.Sprintf("Edge out vertex of RID %s cannot be found in database", e.vertex[Out]))
 	}
-	return vs[0], nil
+	return c.unpackVertex(raw)
 }
 
 /* From returns Vertex when the Edge ends ("in" Vertex). */
 func (e *Edge) To(c *Connection) (*Vertex, error) {
 	if (*c).vertexes[e.vertex[In]] != nil {
+		c.touch(e.vertex[In])
 		return (*c).vertexes[e.vertex[In]], nil
 	}
-	vs, err := (*c).SelectVertexes(e.vertex[In], 1, "")
+	raw, err := c.transport().Document(c, e.vertex[In])
 	if err != nil {
 		return nil, err
 	}
-	if len(vs) != 1 {
+	if raw == nil {
 		return nil, errors.New(fmt.Sprintf("Edge in vertex of RID %s cannot be found in database", e.vertex[In]))
 	}
-	return vs[0], nil
+	return c.unpackVertex(raw)
 }
 
 /* Edges returns edges/has that given Vertex has. */
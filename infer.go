@@ -0,0 +1,323 @@
+package sheikh
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxDistinct limits number of distinct values of a property remembered during inference.
+const maxDistinct = 1000
+
+// PropertyStats summarizes values of a property found in sampled records of a class.
+type PropertyStats struct {
+	Name       string
+	Types      map[string]int // numbers of non-null values by OrientDB type
+	Present    int            // number of records having the property
+	Nulls      int            // number of null values
+	HasRange   bool           // whether Min and Max are set
+	Min, Max   float64        // range of numbers, lengths of strings and sizes of collections
+	Distinct   int            // number of distinct values, counted up to 1000
+	LinkedType string         // type of all elements of collections, if they have one
+}
+
+// ClassStats summarizes records of a class sampled by InferClass.
+type ClassStats struct {
+	Class      string
+	Edge       bool
+	Sampled    int
+	Complete   bool            // whether all records of the class were sampled
+	Properties []PropertyStats // sorted by name
+}
+
+var (
+	ridPattern      = regexp.MustCompile(`^#\d+:\d+$`)
+	datePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	datetimePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(\.\d+)?$`)
+)
+
+// fieldTypeCodes maps codes used in @fieldTypes of JSON records to OrientDB types.
+var fieldTypeCodes = map[string]string{
+	"f": "FLOAT", "c": "DECIMAL", "l": "LONG", "d": "DOUBLE", "b": "BINARY", "y": "BYTE", "s": "SHORT",
+	"a": "DATE", "t": "DATETIME", "e": "EMBEDDEDSET", "g": "LINKBAG", "z": "LINKLIST", "m": "LINKMAP",
+	"x": "LINK", "n": "LINKSET", "u": "CUSTOM",
+}
+
+// valueType guesses OrientDB type of a value decoded from JSON.
+func valueType(val interface{}) string {
+	switch t := val.(type) {
+	case bool:
+		return "BOOLEAN"
+	case float64:
+		if t != math.Trunc(t) {
+			return "DOUBLE"
+		}
+		if t >= math.MinInt32 && t <= math.MaxInt32 {
+			return "INTEGER"
+		}
+		return "LONG"
+	case int64, uint64:
+		return "LONG"
+	case string:
+		switch {
+		case ridPattern.MatchString(t):
+			return "LINK"
+		case datetimePattern.MatchString(t):
+			return "DATETIME"
+		case datePattern.MatchString(t):
+			return "DATE"
+		}
+		return "STRING"
+	case []interface{}:
+		for _, elem := range t {
+			if str, ok := elem.(string); !ok || !ridPattern.MatchString(str) {
+				return "EMBEDDEDLIST"
+			}
+		}
+		if len(t) == 0 {
+			return "EMBEDDEDLIST"
+		}
+		return "LINKLIST"
+	case map[string]interface{}:
+		if _, isDoc := t["@type"]; isDoc {
+			return "EMBEDDED"
+		}
+		return "EMBEDDEDMAP"
+	}
+	return "ANY"
+}
+
+/* commonType returns type able to hold values of both types, e.g. LONG for INTEGER and LONG, or false if there is
+no such type. */
+func commonType(a, b string) (string, bool) {
+	if a == b {
+		return a, true
+	}
+	rank := map[string]int{"BYTE": 1, "SHORT": 2, "INTEGER": 3, "LONG": 4, "FLOAT": 5, "DOUBLE": 6, "DECIMAL": 7}
+	if rank[a] != 0 && rank[b] != 0 {
+		if rank[a] > rank[b] {
+			return a, true
+		}
+		return b, true
+	}
+	pairs := map[[2]string]string{
+		{"DATE", "DATETIME"}:            "DATETIME",
+		{"EMBEDDEDLIST", "LINKLIST"}:    "EMBEDDEDLIST",
+		{"EMBEDDEDSET", "LINKSET"}:      "EMBEDDEDSET",
+		{"EMBEDDED", "EMBEDDEDMAP"}:     "EMBEDDEDMAP",
+		{"EMBEDDEDLIST", "LINKSET"}:     "EMBEDDEDLIST",
+		{"EMBEDDEDSET", "LINKLIST"}:     "EMBEDDEDSET",
+		{"EMBEDDEDLIST", "LINKBAG"}:     "EMBEDDEDLIST",
+		{"LINKLIST", "LINKBAG"}:         "LINKLIST",
+		{"LINKSET", "LINKBAG"}:          "LINKSET",
+		{"EMBEDDEDMAP", "LINKMAP"}:      "EMBEDDEDMAP",
+		{"EMBEDDEDLIST", "EMBEDDEDSET"}: "EMBEDDEDLIST",
+	}
+	if common, ok := pairs[[2]string{a, b}]; ok {
+		return common, true
+	}
+	if common, ok := pairs[[2]string{b, a}]; ok {
+		return common, true
+	}
+	return "", false
+}
+
+/* addValue records a value of the property, of given OrientDB type, in the stats. Distinct values and types of
+collection elements are gathered in the maps. */
+func (ps *PropertyStats) addValue(val interface{}, odbType string, distinct, elemTypes map[string]bool) {
+	ps.Present++
+	if val == nil {
+		ps.Nulls++
+		return
+	}
+	ps.Types[odbType]++
+	if size, sized := valueSize(val); sized && odbType != "DATE" && odbType != "DATETIME" && odbType != "LINK" {
+		if !ps.HasRange || size < ps.Min {
+			ps.Min = size
+		}
+		if !ps.HasRange || size > ps.Max {
+			ps.Max = size
+		}
+		ps.HasRange = true
+	}
+	if len(distinct) < maxDistinct {
+		distinct[toOdbRepr(val)] = true
+		ps.Distinct = len(distinct)
+	}
+	if elems, ok := val.([]interface{}); ok {
+		for _, elem := range elems {
+			elemTypes[valueType(elem)] = true
+		}
+	}
+}
+
+/* Type returns the type which can hold all values of the property found in the sample, or false if the values have
+conflicting types or were all null. */
+func (ps *PropertyStats) Type() (string, bool) {
+	var types []string
+	for odbType := range ps.Types {
+		types = append(types, odbType)
+	}
+	if len(types) == 0 {
+		return "", false
+	}
+	sort.Strings(types)
+	ret := types[0]
+	for _, odbType := range types[1:] {
+		var ok bool
+		if ret, ok = commonType(ret, odbType); !ok {
+			return "", false
+		}
+	}
+	return ret, true
+}
+
+/* InferClass samples up to sample records of the class (all of them, if sample isn't positive) and summarizes their
+properties. It's meant for classes without a declared schema, to see what they actually contain. The sample is not
+random: it's the first records in the order the server returns them (usually the order of storage). Sampled records
+are not added to the index of the Connection. */
+func (c *Connection) InferClass(class string, sample int) (*ClassStats, error) {
+	schema, err := c.Schema()
+	if err != nil {
+		return nil, err
+	}
+	return c.inferClass(schema, class, sample)
+}
+
+func (c *Connection) inferClass(schema *Schema, class string, sample int) (*ClassStats, error) {
+	if schema.Class(class) == nil {
+		return nil, errors.New(fmt.Sprintf("InferClass: class %s doesn't exist", class))
+	}
+	cs := &ClassStats{Class: class, Edge: schema.IsA(class, "E")}
+	comText := "SELECT FROM " + class
+	if sample > 0 {
+		comText += fmt.Sprintf(" LIMIT %v", sample)
+	}
+	rows, err := (*c).Command(comText) // decoded here, so that the sample doesn't fill the index
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]interface{}
+	for _, row := range rows {
+		rec, ok := row.(map[string]interface{})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("InferClass: unexpected record of %s: %v", class, row))
+		}
+		records = append(records, rec)
+	}
+	cs.Sampled = len(records)
+	cs.Complete = sample <= 0 || len(records) < sample
+	cs.Properties = collectStats(records, cs.Edge)
+	return cs, nil
+}
+
+/* collectStats summarizes properties of the records; @fieldTypes sent by the server take precedence over guessing.
+Relations (out_* and in_* fields of vertexes, out and in of edges) are skipped. */
+func collectStats(records []map[string]interface{}, edge bool) []PropertyStats {
+	stats := make(map[string]*PropertyStats)
+	distinct, elemTypes := make(map[string]map[string]bool), make(map[string]map[string]bool)
+	for _, rec := range records {
+		fieldTypes := make(map[string]string)
+		if codes, ok := rec["@fieldTypes"].(string); ok {
+			for _, pair := range strings.Split(codes, ",") {
+				if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && fieldTypeCodes[kv[1]] != "" {
+					fieldTypes[kv[0]] = fieldTypeCodes[kv[1]]
+				}
+			}
+		}
+		for name, val := range rec {
+			relation := strings.HasPrefix(name, "out_") || strings.HasPrefix(name, "in_")
+			if edge {
+				relation = name == "out" || name == "in"
+			}
+			if strings.HasPrefix(name, "@") || relation {
+				continue
+			}
+			ps, present := stats[name]
+			if !present {
+				ps = &PropertyStats{Name: name, Types: make(map[string]int)}
+				stats[name] = ps
+				distinct[name], elemTypes[name] = make(map[string]bool), make(map[string]bool)
+			}
+			odbType := fieldTypes[name]
+			if odbType == "" {
+				odbType = valueType(val)
+			}
+			ps.addValue(val, odbType, distinct[name], elemTypes[name])
+		}
+	}
+	var ret []PropertyStats
+	for _, ps := range stats {
+		if len(elemTypes[ps.Name]) == 1 {
+			for elemType := range elemTypes[ps.Name] {
+				ps.LinkedType = elemType
+			}
+		}
+		ret = append(ret, *ps)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+/* Propose returns class with properties inferred from the stats: the ones present in all sampled records are
+mandatory, and ones which were never null are not null. Properties with conflicting types are left out. */
+func (cs *ClassStats) Propose() Class {
+	cl := Class{Name: cs.Class}
+	for _, ps := range cs.Properties {
+		odbType, ok := ps.Type()
+		if !ok {
+			continue
+		}
+		p := Property{Name: ps.Name, Type: odbType, NotNull: ps.Nulls == 0,
+			Mandatory: cs.Sampled != 0 && ps.Present == cs.Sampled}
+		switch odbType {
+		case "EMBEDDEDLIST", "EMBEDDEDSET":
+			switch ps.LinkedType {
+			case "", "ANY", "EMBEDDED", "EMBEDDEDMAP", "EMBEDDEDLIST", "LINKLIST":
+			default:
+				p.LinkedType = ps.LinkedType
+			}
+		}
+		cl.Properties = append(cl.Properties, p)
+	}
+	return cl
+}
+
+/* InferSchema samples records of the classes (see InferClass) and returns schema proposing properties they lack,
+keeping already declared ones intact. It can be saved as JSON for LoadSchema, or applied to tighten the schema
+incrementally, e.g. Plan returns just the CREATE PROPERTY and ALTER PROPERTY statements for new properties. */
+func (c *Connection) InferSchema(sample int, classes ...string) (*Schema, []*ClassStats, error) {
+	current, err := c.Schema()
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := &Schema{}
+	var allStats []*ClassStats
+	for _, class := range classes {
+		cs, err := c.inferClass(current, class, sample)
+		if err != nil {
+			return nil, nil, err
+		}
+		curCl := current.Class(class)
+		allStats = append(allStats, cs)
+		cl := cs.Propose()
+		cl.SuperClasses, cl.Abstract = curCl.SuperClasses, curCl.Abstract
+		declared := current.AllProperties(class)
+		var props []Property
+		for _, p := range cl.Properties {
+			isDeclared := false
+			for _, d := range declared {
+				isDeclared = isDeclared || d.Name == p.Name
+			}
+			if !isDeclared {
+				props = append(props, p)
+			}
+		}
+		cl.Properties = append(append([]Property(nil), curCl.Properties...), props...)
+		ret.Classes = append(ret.Classes, cl)
+	}
+	return ret, allStats, nil
+}
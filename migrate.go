@@ -0,0 +1,289 @@
+package sheikh
+
+import (
+	"chillson"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Migration is a single step of evolving the database (its schema or data). Up applies it and Down reverts it, e.g.
+with Connection.Command or Connection.Batch; if they are nil, UpScript and DownScript SQL statements are run instead,
+as one batch script (without transaction, so that they can change the schema). */
+type Migration struct {
+	Version    int // migrations are applied in order of versions, which should be unique
+	Name       string
+	Up, Down   func(c *Connection) error
+	UpScript   []string
+	DownScript []string
+}
+
+// Latest can be passed to Migrate to apply all registered migrations.
+const Latest = -1
+
+const (
+	migrationsClass     = "SheikhMigration"
+	migrationsLockClass = "SheikhMigrationLock"
+)
+
+var migrations = make(map[int]Migration)
+
+/* RegisterMigration adds migration to the ones run by Migrate. It's meant to be called from init() functions of
+packages containing migrations. It panics on duplicated versions; LoadMigrations returns an error instead. */
+func RegisterMigration(m Migration) {
+	if _, present := migrations[m.Version]; present {
+		panic(fmt.Sprintf("RegisterMigration: migration version %v registered twice", m.Version))
+	}
+	migrations[m.Version] = m
+}
+
+/* ResetMigrations unregisters all migrations, e.g. so that a test registering or loading them can be run again in
+the same process. */
+func ResetMigrations() {
+	migrations = make(map[int]Migration)
+}
+
+/* LoadMigrations registers migrations from .osql script files in the directory. Files should be named
+<version>_<name>.up.osql and <version>_<name>.down.osql, e.g. 0001_create_gopher.up.osql. Statements in scripts end
+with semicolons; lines starting with -- are comments. Down scripts are optional. Nothing is registered if any of
+versions is registered already. */
+func LoadMigrations(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.osql"))
+	if err != nil {
+		return err
+	}
+	loaded := make(map[int]*Migration)
+	for _, path := range paths {
+		base := filepath.Base(path)
+		var up bool
+		switch {
+		case strings.HasSuffix(base, ".up.osql"):
+			up, base = true, strings.TrimSuffix(base, ".up.osql")
+		case strings.HasSuffix(base, ".down.osql"):
+			base = strings.TrimSuffix(base, ".down.osql")
+		default:
+			return errors.New(fmt.Sprintf("LoadMigrations: %s is neither .up.osql nor .down.osql file", path))
+		}
+		sep := strings.Index(base, "_")
+		if sep == -1 {
+			sep = len(base)
+		}
+		version, err := strconv.Atoi(base[:sep])
+		if err != nil {
+			return errors.New(fmt.Sprintf("LoadMigrations: %s doesn't start with a version number", path))
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		m, present := loaded[version]
+		if !present {
+			m = &Migration{Version: version, Name: strings.TrimPrefix(base[sep:], "_")}
+			loaded[version] = m
+		}
+		if up {
+			m.UpScript = splitScript(string(content))
+		} else {
+			m.DownScript = splitScript(string(content))
+		}
+	}
+	for _, m := range loaded {
+		if m.UpScript == nil {
+			return errors.New(fmt.Sprintf("LoadMigrations: migration %v has no .up.osql file", m.Version))
+		}
+		if _, present := migrations[m.Version]; present {
+			return errors.New(fmt.Sprintf("LoadMigrations: migration version %v is registered already", m.Version))
+		}
+	}
+	for _, m := range loaded {
+		RegisterMigration(*m)
+	}
+	return nil
+}
+
+// splitScript splits SQL script into statements ending with semicolons, skipping empty lines and -- comments.
+func splitScript(script string) []string {
+	var ret, lines []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.HasSuffix(line, ";") {
+			ret = append(ret, strings.TrimSuffix(strings.Join(lines, " "), ";"))
+			lines = nil
+		}
+	}
+	if len(lines) != 0 { // last statement without a semicolon
+		ret = append(ret, strings.Join(lines, " "))
+	}
+	return ret
+}
+
+// prepareMigrations creates classes for migrations history and lock, if they don't exist yet.
+func (c *Connection) prepareMigrations() error {
+	s := Schema{
+		Classes: []Class{
+			{Name: migrationsClass, Properties: []Property{
+				{Name: "version", Type: "INTEGER", Mandatory: true},
+				{Name: "name", Type: "STRING"},
+				{Name: "appliedAt", Type: "DATETIME"},
+			}},
+			{Name: migrationsLockClass, Properties: []Property{
+				{Name: "name", Type: "STRING", Mandatory: true},
+				{Name: "holder", Type: "STRING"},
+				{Name: "since", Type: "DATETIME"},
+			}},
+		},
+		Indexes: []Index{
+			{Class: migrationsClass, Type: "UNIQUE", Fields: []string{"version"}},
+			{Class: migrationsLockClass, Type: "UNIQUE", Fields: []string{"name"}},
+		},
+	}
+	_, err := s.Apply(c)
+	return err
+}
+
+/* lockMigrations creates the lock record, which has unique name, so only one process at a time can succeed. The
+error tells who holds the lock otherwise. */
+func (c *Connection) lockMigrations() error {
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("%s/%v", host, os.Getpid())
+	_, err := (*c).Command(fmt.Sprintf("INSERT INTO %s SET name = \"migrate\", holder = %s, since = sysdate()",
+		migrationsLockClass, toOdbRepr(holder)))
+	if err == nil {
+		return nil
+	}
+	res, selErr := (*c).Command(fmt.Sprintf("SELECT FROM %s WHERE name = \"migrate\"", migrationsLockClass))
+	if selErr != nil || len(res) == 0 {
+		return err
+	}
+	chill := chillson.Son{res}
+	lockHolder, _ := chill.GetStr("[0][holder]")
+	since, _ := chill.Get("[0][since]")
+	return errors.New(fmt.Sprintf("Migrate: migrations are locked by %s since %v", lockHolder, since))
+}
+
+/* ForceUnlockMigrations removes the lock taken by Migrate. The lock is released when Migrate returns, but it stays
+in the database if the process migrating it crashed or lost connection; then Migrate fails telling who holds the lock
+and since when. Make sure the holder isn't running anymore, as migrations would run concurrently otherwise. */
+func (c *Connection) ForceUnlockMigrations() error {
+	if err := c.prepareMigrations(); err != nil {
+		return err
+	}
+	return c.unlockMigrations()
+}
+
+func (c *Connection) unlockMigrations() error {
+	_, err := (*c).Command(fmt.Sprintf("DELETE FROM %s WHERE name = \"migrate\"", migrationsLockClass))
+	return err
+}
+
+// AppliedMigrations returns versions of migrations applied to the database, in ascending order.
+func (c *Connection) AppliedMigrations() ([]int, error) {
+	if err := c.prepareMigrations(); err != nil {
+		return nil, err
+	}
+	return c.appliedVersions()
+}
+
+func (c *Connection) appliedVersions() ([]int, error) {
+	res, err := (*c).Command(fmt.Sprintf("SELECT version FROM %s ORDER BY version", migrationsClass))
+	if err != nil {
+		return nil, err
+	}
+	var ret []int
+	for ind := range res {
+		chill := chillson.Son{res[ind]}
+		version, err := chill.GetInt("[version]")
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, version)
+	}
+	return ret, nil
+}
+
+func (c *Connection) runMigration(m Migration, up bool) error {
+	fn, script := m.Down, m.DownScript
+	if up {
+		fn, script = m.Up, m.UpScript
+	}
+	if fn != nil {
+		return fn(c)
+	}
+	if script == nil {
+		return errors.New(fmt.Sprintf("migration %v (%s) can't be reverted", m.Version, m.Name))
+	}
+	_, err := c.batch(false, script)
+	return err
+}
+
+/* Migrate brings the database to the target version (or Latest), applying registered migrations which weren't applied
+yet, or reverting applied ones of versions above target. Applied versions are stored in SheikhMigration class, and
+a lock record in SheikhMigrationLock class ensures that two processes won't migrate the same database at once. Each
+migration is recorded as soon as it succeeds, so Migrate can be run again after fixing a failed one. A lock left by
+a crashed process has to be removed with ForceUnlockMigrations. */
+func (c *Connection) Migrate(target int) error {
+	if err := c.prepareMigrations(); err != nil {
+		return err
+	}
+	if err := c.lockMigrations(); err != nil {
+		return err
+	}
+	defer c.unlockMigrations()
+	applied, err := c.appliedVersions()
+	if err != nil {
+		return err
+	}
+	isApplied := make(map[int]bool)
+	for _, version := range applied {
+		isApplied[version] = true
+	}
+	var versions []int
+	for version := range migrations {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+
+	for ind := len(applied) - 1; ind >= 0; ind-- { // revert, newest first
+		version := applied[ind]
+		if target == Latest || version <= target {
+			break
+		}
+		m, present := migrations[version]
+		if !present {
+			return errors.New(fmt.Sprintf("Migrate: applied migration %v is not registered, can't revert it", version))
+		}
+		if err = c.runMigration(m, false); err != nil {
+			return errors.New(fmt.Sprintf("Migrate: reverting %v (%s): %v", m.Version, m.Name, err))
+		}
+		_, err = (*c).Command(fmt.Sprintf("DELETE FROM %s WHERE version = %v", migrationsClass, version))
+		if err != nil {
+			return err
+		}
+	}
+	for _, version := range versions { // apply, oldest first
+		if target != Latest && version > target {
+			break
+		}
+		if isApplied[version] {
+			continue
+		}
+		m := migrations[version]
+		if err = c.runMigration(m, true); err != nil {
+			return errors.New(fmt.Sprintf("Migrate: applying %v (%s): %v", m.Version, m.Name, err))
+		}
+		_, err = (*c).Command(fmt.Sprintf("INSERT INTO %s SET version = %v, name = %s, appliedAt = sysdate()",
+			migrationsClass, version, toOdbRepr(m.Name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
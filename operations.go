@@ -4,21 +4,231 @@ import (
 	"chillson"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
-/* DeleteEdge removes Edge(s) of requested RID(s) from the database. */
+/* DeleteEdge removes Edge(s) of requested RID(s) from the database. They are also removed from the index of the
+Connection and from relations of indexed vertexes. */
 func (c *Connection) DeleteEdges(rids ...string) error {
 	comText := fmt.Sprintf("DELETE EDGE %s", strings.Join(rids, ","))
 	_, err := (*c).Command(comText)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, rid := range rids {
+		c.forgetEdge(rid)
+	}
+	return nil
 }
 
-/* DeleteEdge removes Vertex(es) of requested RID(s) from the database. */
+/* DeleteEdge removes Vertex(es) of requested RID(s) from the database. They are also removed from the index of the
+Connection, along with their edges (which are deleted by the server too). */
 func (c *Connection) DeleteVertexes(rids ...string) error {
-	comText := fmt.Sprintf("DELETE VERTEX %s", strings.Join(rids, ","))
-	_, err := (*c).Command(comText)
-	return err
+	joined := strings.Join(rids, ",")
+	res, err := c.batch(true, []string{
+		fmt.Sprintf("LET deleted = SELECT @rid AS rid, bothE() AS edges FROM [%s]", joined),
+		fmt.Sprintf("DELETE VERTEX %s", joined),
+		"RETURN $deleted",
+	})
+	if err != nil {
+		return err
+	}
+	if err = c.forgetVertexes(res); err != nil {
+		return err
+	}
+	for _, rid := range rids {
+		c.forgetVertex(rid)
+	}
+	return nil
+}
+
+// removeRel returns rels without relation to the edge of given RID.
+func removeRel(rels []vtxRel, edgeRid string) []vtxRel {
+	var kept []vtxRel
+	for _, rel := range rels {
+		if rel.edgeRid != edgeRid {
+			kept = append(kept, rel)
+		}
+	}
+	return kept
+}
+
+// unlinkEdge removes the edge of given RID from relations of the vertex.
+func (v *Vertex) unlinkEdge(edgeRid string) {
+	for _, dirn := range []EdgeDirection{In, Out} {
+		for class, rels := range v.edges[dirn] {
+			if rels = removeRel(rels, edgeRid); len(rels) == 0 {
+				delete(v.edges[dirn], class)
+			} else {
+				v.edges[dirn][class] = rels
+			}
+		}
+	}
+}
+
+/* forgetEdge removes deleted edge from the index and from relations of indexed vertexes. When the edge itself isn't
+indexed, its vertexes are not known, so all of them are checked. */
+func (c *Connection) forgetEdge(rid string) {
+	if e, present := c.edges[rid]; present {
+		for _, vtxRid := range e.vertex {
+			if vtx, present := c.vertexes[vtxRid]; present {
+				vtx.unlinkEdge(rid)
+			}
+		}
+		delete(c.edges, rid)
+		c.untouch(rid)
+		return
+	}
+	for _, vtx := range c.vertexes {
+		vtx.unlinkEdge(rid)
+	}
+}
+
+/* forgetVertex removes deleted vertex from the index, along with all indexed edges coming in or out of it, as the
+server removes them too. */
+func (c *Connection) forgetVertex(rid string) {
+	if vtx, present := c.vertexes[rid]; present {
+		for _, dirn := range []EdgeDirection{In, Out} {
+			for _, rels := range vtx.edges[dirn] {
+				for _, rel := range rels {
+					c.forgetEdge(rel.edgeRid)
+				}
+			}
+		}
+		delete(c.vertexes, rid)
+		c.untouch(rid)
+	}
+	for edgeRid, e := range c.edges {
+		if e.vertex[In] == rid || e.vertex[Out] == rid {
+			c.forgetEdge(edgeRid)
+		}
+	}
+}
+
+/* forgetVertexes processes records of deleted vertexes, selected with their RID as rid and bothE() as edges, removing
+the vertexes and their edges from the index and from relations of other vertexes. Edges are given by the server, as
+neither the vertex nor its edges have to be indexed. */
+func (c *Connection) forgetVertexes(res []interface{}) error {
+	for _, rec := range res {
+		chill := chillson.Son{rec}
+		rid, err := chill.GetStr("[rid]")
+		if err != nil {
+			return err
+		}
+		edges, err := chill.GetArr("[edges]")
+		if err != nil {
+			return err
+		}
+		for _, rawEdgeRid := range edges {
+			edgeRid, ok := rawEdgeRid.(string)
+			if !ok {
+				return errors.New(fmt.Sprintf("Cannot process edges of deleted vertex %s", rid))
+			}
+			c.forgetEdge(edgeRid)
+		}
+		c.forgetVertex(rid)
+	}
+	return nil
+}
+
+// evict removes records of given RIDs from the index of vertexes and edges received from the db.
+func (c *Connection) evict(rids []string) {
+	for _, rid := range rids {
+		delete(c.vertexes, rid)
+		delete(c.edges, rid)
+		c.untouch(rid)
+	}
+}
+
+/* deleteWhere deletes records in one transaction with selecting their RIDs, so that exactly the deleted ones are
+removed from the index. */
+func (c *Connection) deleteWhere(kind, class, condition string, params []interface{}) (int, error) {
+	if condition == "" {
+		return 0, errors.New(fmt.Sprintf("Delete %s: empty condition", class))
+	}
+	condition, err := bindParams(condition, params)
+	if err != nil {
+		return 0, err
+	}
+	projection := "@rid AS rid"
+	if kind == "VERTEX" {
+		projection += ", bothE() AS edges"
+	}
+	res, err := c.batch(true, []string{
+		fmt.Sprintf("LET deleted = SELECT %s FROM %s WHERE %s", projection, class, condition),
+		fmt.Sprintf("DELETE %s %s WHERE %s", kind, class, condition),
+		"RETURN $deleted",
+	})
+	if err != nil {
+		return 0, err
+	}
+	if kind == "VERTEX" {
+		return len(res), c.forgetVertexes(res)
+	}
+	for _, rec := range res {
+		chill := chillson.Son{rec}
+		rid, err := chill.GetStr("[rid]")
+		if err != nil {
+			return 0, err
+		}
+		c.forgetEdge(rid)
+	}
+	return len(res), nil
+}
+
+/* DeleteEdgesWhere removes edges of given class matching the condition, and returns their count. Condition can contain
+? placeholders, which are replaced with params (in order). */
+func (c *Connection) DeleteEdgesWhere(class, condition string, params ...interface{}) (int, error) {
+	return c.deleteWhere("EDGE", class, condition, params)
+}
+
+/* DeleteVertexesWhere removes vertexes of given class matching the condition, and returns their count. Condition can
+contain ? placeholders, which are replaced with params (in order), e.g.
+   c.DeleteVertexesWhere("Gopher", "name = ? AND age > ?", "Bob", 10) */
+func (c *Connection) DeleteVertexesWhere(class, condition string, params ...interface{}) (int, error) {
+	return c.deleteWhere("VERTEX", class, condition, params)
+}
+
+/* UpdateWhere sets properties given in setFields on all records of the class which match the condition, and returns
+their count. Condition can contain ? placeholders, which are replaced with params (in order). Updated records are
+removed from the index of the Connection, as their local copies are not up to date anymore. */
+func (c *Connection) UpdateWhere(class string, setFields map[string]interface{}, condition string,
+	params ...interface{}) (int, error) {
+	if len(setFields) == 0 {
+		return 0, errors.New("UpdateWhere: no fields to set")
+	}
+	if condition == "" {
+		return 0, errors.New("UpdateWhere: empty condition")
+	}
+	condition, err := bindParams(condition, params)
+	if err != nil {
+		return 0, err
+	}
+	var labels, setList []string
+	for label := range setFields {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		setList = append(setList, fmt.Sprintf("%s = %s", label, toOdbRepr(setFields[label])))
+	}
+	comText := fmt.Sprintf("UPDATE %s SET %s RETURN BEFORE @rid WHERE %s", class, strings.Join(setList, ", "), condition)
+	res, err := (*c).Command(comText)
+	if err != nil {
+		return 0, err
+	}
+	var rids []string
+	for _, rec := range res {
+		chill := chillson.Son{rec}
+		rid, err := chill.GetStr("[value]")
+		if err != nil {
+			return 0, err
+		}
+		rids = append(rids, rid)
+	}
+	c.evict(rids)
+	return len(rids), nil
 }
 
 func (c *Connection) insertEntry(entry *Doc, entryComText string) error {
@@ -32,31 +242,183 @@ func (c *Connection) insertEntry(entry *Doc, entryComText string) error {
 	chill := chillson.Son{ret}
 	(*entry).Rid, err = chill.GetStr("[0][@rid]")
 	(*entry).Version = 1
+	(*entry).diff = nil
+	markSynced(entry)
 	return err
 }
 
 /* InsertEdge inserts given edge to the database, and assings proper RID and Version values to it.*/
 func (c *Connection) InsertEdge(e *Edge) error {
+	if err := c.validate(&e.Entry, true, true); err != nil {
+		return err
+	}
 	comText := fmt.Sprintf("CREATE EDGE %s FROM %s TO %s", (*e).Entry.Class, e.vertex[Out], e.vertex[In])
 	err := c.insertEntry(&e.Entry, comText)
 	if err != nil {
 		return err
 	}
+	c.linkEdge(e)
+	return nil
+}
+
+// linkEdge adds newly created edge to relations of its indexed vertexes.
+func (c *Connection) linkEdge(e *Edge) {
 	if vtx, present := c.vertexes[e.vertex[Out]]; present {
 		vtx.edges[Out][e.Entry.Class] = append(vtx.edges[Out][e.Entry.Class], vtxRel{e.Entry.Rid})
+		c.touch(e.vertex[Out])
 	}
 	if vtx, present := c.vertexes[e.vertex[In]]; present {
 		vtx.edges[In][e.Entry.Class] = append(vtx.edges[In][e.Entry.Class], vtxRel{e.Entry.Rid})
+		c.touch(e.vertex[In])
 	}
-	return nil
+}
+
+/* InsertEdges creates edges of given class from every vertex selected by from to every vertex selected by to, and
+returns them. Both from and to can be a RID, a list of RIDs made with RidList or a subquery in parentheses, e.g.
+   c.InsertEdges("owes", RidList(v1.Entry.Rid, v2.Entry.Rid), "(SELECT FROM Gopher WHERE name = 'Bob')", nil)
+Props (which can be nil) are set on every created edge. */
+func (c *Connection) InsertEdges(className, from, to string, props map[string]interface{}) ([](*Edge), error) {
+	comText := fmt.Sprintf("CREATE EDGE %s FROM %s TO %s", className, from, to)
+	if len(props) > 0 {
+		comText += fmt.Sprintf(" CONTENT %s", toOdbRepr(props))
+	}
+	res, err := (*c).Command(comText)
+	if err != nil {
+		return nil, err
+	}
+	var ret [](*Edge)
+	for ind := range res {
+		e, err := c.unpackEdge(res[ind])
+		if err != nil {
+			return ret, errors.New("InsertEdges: " + err.Error())
+		}
+		c.linkEdge(e)
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// propsArgs flattens props map to label-value arguments of SetProps, sorted by labels.
+func propsArgs(props map[string]interface{}) []interface{} {
+	var labels []string
+	for label := range props {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	var args []interface{}
+	for _, label := range labels {
+		args = append(args, label, props[label])
+	}
+	return args
+}
+
+/* EnsureEdge returns the edge of given class going from one vertex to another, creating it only if there is none yet,
+so it's safe to retry. Props (which can be nil) are set on the created edge, or updated on the existing one. If the
+class has a unique index declared with DeclareUniqueEdges, concurrent creation of the same edge is also handled. */
+func (c *Connection) EnsureEdge(from *Vertex, className string, to *Vertex,
+	props map[string]interface{}) (e *Edge, created bool, err error) {
+	cond := fmt.Sprintf("WHERE out = %s AND in = %s", from.Entry.Rid, to.Entry.Rid)
+	es, err := c.SelectEdges(className, 1, cond)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(es) == 0 {
+		newE := CreateEdge(from, className, to)
+		if len(props) > 0 {
+			newE.SetProps(propsArgs(props)...)
+		}
+		insertErr := c.InsertEdge(&newE)
+		if insertErr == nil {
+			return &newE, true, nil
+		}
+		// Maybe someone was faster and the unique index stopped us, check again.
+		if es, err = c.SelectEdges(className, 1, cond); err != nil || len(es) == 0 {
+			return nil, false, insertErr
+		}
+	}
+	e = es[0]
+	if len(props) > 0 {
+		e.SetProps(propsArgs(props)...)
+		err = c.UpdateEdge(e)
+	}
+	return e, false, err
+}
+
+/* DeclareUniqueEdges creates unique index on (out, in) pairs of the edge class, so the database won't allow more than
+one edge of this class between the same vertexes. It does nothing if the index already exists. */
+func (c *Connection) DeclareUniqueEdges(className string) error {
+	for _, end := range []string{"out", "in"} {
+		if err := c.CreateProperty(className, Property{Name: end, Type: "LINK"}, true); err != nil {
+			return err
+		}
+	}
+	return c.CreateIndex(Index{Class: className, Type: "UNIQUE", Fields: []string{"out", "in"}}, true)
+}
+
+// RidList formats RIDs as a list for use in queries, e.g. as from or to argument of InsertEdges.
+func RidList(rids ...string) string {
+	return "[" + strings.Join(rids, ", ") + "]"
 }
 
 /* InsertVertex inserts given vertex to the database, and assings proper RID and Version values to it.*/
 func (c *Connection) InsertVertex(v *Vertex) error {
+	if err := c.validate(&v.Entry, true, false); err != nil {
+		return err
+	}
 	comText := fmt.Sprintf("CREATE VERTEX %s", (*v).Entry.Class)
 	return c.insertEntry(&v.Entry, comText)
 }
 
+/* UpsertVertex updates vertex of v's class which has the same values of whereFields properties as v (merging all
+properties of v into it), or inserts v when there is no such vertex. Rid, Version and properties of v are refreshed
+from the stored record, and created tells whether the vertex was inserted. The server selects the matching vertex and
+runs the UPSERT in one transaction, but it's atomic only when there is a unique index on whereFields; without it,
+concurrent calls can insert duplicated vertexes. Only vertexes can be upserted, as documents of other classes are not
+handled by the driver, and edges need their vertexes set on insert. */
+func (c *Connection) UpsertVertex(v *Vertex, whereFields ...string) (created bool, err error) {
+	if len(whereFields) == 0 {
+		return false, errors.New("UpsertVertex: no fields to match the vertex by")
+	}
+	var conds []string
+	for _, field := range whereFields {
+		val, present := v.Entry.propsContainer[field]
+		if !present {
+			return false, errors.New(fmt.Sprintf("UpsertVertex: vertex has no %s property to match by", field))
+		}
+		conds = append(conds, fmt.Sprintf("%s = %s", field, toOdbRepr(val)))
+	}
+	where := strings.Join(conds, " AND ")
+	res, err := c.batch(true, []string{
+		fmt.Sprintf("LET before = SELECT @rid AS rid FROM %s WHERE %s", v.Entry.Class, where),
+		fmt.Sprintf("LET after = UPDATE %s MERGE %s UPSERT RETURN AFTER WHERE %s", v.Entry.Class,
+			toOdbRepr(v.Entry.propsContainer), where),
+		"RETURN unionall($before, $after)",
+	})
+	if err != nil {
+		return false, err
+	}
+	created = true
+	var after []interface{}
+	for _, rec := range res {
+		if fields, _ := rec.(map[string]interface{}); fields["@class"] == nil { // RID of the vertex matched before
+			created = false
+		} else {
+			after = append(after, rec)
+		}
+	}
+	if len(after) != 1 {
+		return false, errors.New(fmt.Sprintf("UpsertVertex: %v records affected, should be 1", len(after)))
+	}
+	stored, err := c.unpackVertex(after[0])
+	if err != nil {
+		return false, errors.New("UpsertVertex: " + err.Error())
+	}
+	*v = *stored
+	c.vertexes[v.Entry.Rid] = v
+	c.touch(v.Entry.Rid)
+	return created, nil
+}
+
 func unpackProps(entry *Doc, origEntry interface{}) (err error) {
 	chill := chillson.Son{origEntry}
 	(*entry).Class, err = chill.GetStr("[@class]")
@@ -78,6 +440,25 @@ func unpackProps(entry *Doc, origEntry interface{}) (err error) {
 	return err
 }
 
+// unpackEdge builds Edge from a record returned by the database and adds it to the index.
+func (c *Connection) unpackEdge(rawEntry interface{}) (*Edge, error) {
+	e := newEdge()
+	err := unpackProps(&e.Entry, rawEntry) // TODO: break on err?
+	e.vertex[Out], err = e.PropStr("out")
+	if err == nil {
+		e.vertex[In], err = e.PropStr("in")
+	}
+	if err != nil { // serious business
+		return nil, errors.New(fmt.Sprintf("edge cannot be read properly, error: %v", err))
+	}
+	delete(e.Entry.propsContainer, "out")
+	delete(e.Entry.propsContainer, "in")
+	markSynced(&e.Entry)
+	c.edges[e.Entry.Rid] = &e // add to the index
+	c.touch(e.Entry.Rid)
+	return &e, nil
+}
+
 /* SelectEdges returns a slice of Edges from the database. Target is usually a class, but also can be RID. Pass zero or
 negative limit if you don't wish to specify maximum number of rows. queryParams are added verbatim to the underlying SELECT
 query; it contain e.g. a WHERE condition. */
@@ -90,23 +471,56 @@ func (c *Connection) SelectEdges(target string, limit int, queryParams string) (
 	res, err := (*c).Command(comText)
 	var ret [](*Edge)
 	for ind := range res {
-		e := newEdge()
-		err = unpackProps(&e.Entry, res[ind]) // TODO: break on err?
-		e.vertex[Out], err = e.PropStr("out")
-		if err == nil {
-			e.vertex[In], err = e.PropStr("in")
-		}
-		if err != nil { // serious business
-			return nil, errors.New(fmt.Sprintf("SelectEdges: edge cannot be read properly, error: %v", err))
+		e, err := c.unpackEdge(res[ind])
+		if err != nil {
+			return nil, errors.New("SelectEdges: " + err.Error())
 		}
-		delete(e.Entry.propsContainer, "out")
-		delete(e.Entry.propsContainer, "in")
-		c.edges[e.Entry.Rid] = &e // add to the index
-		ret = append(ret, &e)
+		ret = append(ret, e)
 	}
 	return ret, err
 }
 
+/* unpackVertex builds Vertex from a record returned by the database, including its relations (out_* and in_*
+fields), and adds it to the index. */
+func (c *Connection) unpackVertex(rawEntry interface{}) (*Vertex, error) {
+	v := NewVertex("")
+	err := unpackProps(&v.Entry, rawEntry) // TODO: break on err?
+	var (                                  // for processing edges/relations when they're encountered
+		relClass string
+		relDirn  EdgeDirection
+	)
+	for label, val := range v.Entry.propsContainer {
+		if len(label) > 4 && label[:4] == "out_" {
+			relClass, relDirn = label[4:], Out
+			goto ParseRelations
+		}
+		if len(label) > 3 && label[:3] == "in_" {
+			relClass, relDirn = label[3:], In
+			goto ParseRelations
+		}
+		continue
+
+	ParseRelations:
+		rels, ok := val.([]interface{})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Cannot process edges of type %s", relClass))
+		}
+		v.edges[relDirn][relClass] = nil // initialize
+		for _, rawEdgeRid := range rels {
+			edgeRid, ok := rawEdgeRid.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Cannot process edges of type %s", relClass))
+			}
+			v.edges[relDirn][relClass] = append(v.edges[relDirn][relClass], vtxRel{edgeRid})
+		}
+		delete(v.Entry.propsContainer, label)
+	}
+	markSynced(&v.Entry)
+	c.vertexes[v.Entry.Rid] = &v // add to the index
+	c.touch(v.Entry.Rid)
+	return &v, err
+}
+
 /* SelectVertexes returns a slice of Vertexes from the database. Target is usually a class, but also can be RID. Pass zero or
 negative limit if you don't wish to specify maximum number of rows. queryParams are added verbatim to the underlying SELECT
 query; it contain e.g. a WHERE condition. */
@@ -118,88 +532,198 @@ func (c *Connection) SelectVertexes(target string, limit int, queryParams string
 	res, err := (*c).Command(comText)
 	var ret [](*Vertex)
 	for ind := range res {
-		v := NewVertex("")
-		err = unpackProps(&v.Entry, res[ind]) // TODO: break on err?
-		var (                                 // for processing edges/relations when they're encountered
-			relClass string
-			relDirn  EdgeDirection
-		)
-		for label, val := range v.Entry.propsContainer {
-			if len(label) > 4 && label[:4] == "out_" {
-				relClass, relDirn = label[4:], Out
-				goto ParseRelations
-			}
-			if len(label) > 3 && label[:3] == "in_" {
-				relClass, relDirn = label[3:], In
-				goto ParseRelations
-			}
-			continue
-
-		ParseRelations:
-			rels, ok := val.([]interface{})
-			if !ok {
-				return ret, errors.New(fmt.Sprintf("SelectVertexes: Cannot process edges of type %s", relClass))
-			}
-			v.edges[relDirn][relClass] = nil // initialize
-			for _, rawEdgeRid := range rels {
-				edgeRid := rawEdgeRid.(string)
-				if !ok {
-					return ret, errors.New(fmt.Sprintf("SelectVertexes: Cannot process edges of type %s", relClass))
-				}
-				v.edges[relDirn][relClass] = append(v.edges[relDirn][relClass], vtxRel{edgeRid})
-			}
-			if err != nil { // error when parsing edges/relations
-				return ret, err
-			}
-			delete(v.Entry.propsContainer, label)
+		v, err := c.unpackVertex(res[ind])
+		if err != nil {
+			return ret, errors.New("SelectVertexes: " + err.Error())
 		}
-		c.vertexes[v.Entry.Rid] = &v // add to the index
-		ret = append(ret, &v)
+		ret = append(ret, v)
 	}
 	return ret, err
 }
 
-func (c *Connection) updateEntry(entry *Doc) error {
-	if (*entry).Rid == "" {
-		return errors.New("Update: entity has no associated RID, did it come from the db?")
-	}
-	if (*entry).diff == nil {
-		return nil
-	}
-	comText := fmt.Sprintf("UPDATE %s SET", (*entry).Rid)
-	var removeList []string
+/* updateCommand renders UPDATE command sending changes of the entry to the database. Assignments from extraSet are
+added verbatim. */
+func updateCommand(entry *Doc, extraSet []string) string {
+	setList, removeList := extraSet, []string(nil)
 	for _, label := range (*entry).diff {
-		val, present := (*entry).propsContainer[label]
+		val, present := lookupPath((*entry).propsContainer, label)
 		if !present {
 			removeList = append(removeList, label)
 			continue
 		}
-		comText += fmt.Sprintf(" %s = %s", label, toOdbRepr(val))
+		setList = append(setList, fmt.Sprintf("%s = %s", label, toOdbRepr(val)))
+	}
+	comText := fmt.Sprintf("UPDATE %s", (*entry).Rid)
+	if len(setList) != 0 {
+		comText += " SET " + strings.Join(setList, ", ")
 	}
 	if len(removeList) != 0 {
-		comText += " REMOVE " + strings.Join(removeList, " ")
+		comText += " REMOVE " + strings.Join(removeList, ", ")
 	}
-	comText += " RETURN AFTER @version"
-	resp, err := (*c).Command(comText)
+	return comText + " RETURN AFTER @version"
+}
+
+func (c *Connection) updateEntry(entry *Doc) error {
+	if (*entry).Rid == "" {
+		return errors.New("Update: entity has no associated RID, did it come from the db?")
+	}
+	if len((*entry).diff) == 0 {
+		return nil
+	}
+	resp, err := (*c).Command(updateCommand(entry, nil))
 	if err != nil {
 		return err
 	}
 	chill := chillson.Son{resp}
 	(*entry).Version, err = chill.GetInt("[0][value]")
 	(*entry).diff = nil
+	markSynced(entry)
+	c.touch((*entry).Rid)
 	return err
 }
 
-/* UpdateEdge updates properties of an edge which were changed with SetProp() function since the last sync with
-database. Note it silently returns when no changes to the edge were made. List of changes won't be cleared if any
-error will be encountered. */
+/* moveEdge updates the edge which had its ends changed with SetFrom/SetTo. Along with the edge, relation lists
+(out_* and in_* fields) of old and new vertexes are updated in one transaction. */
+func (c *Connection) moveEdge(e *Edge) error {
+	var script, extraSet []string
+	for _, dirn := range []EdgeDirection{Out, In} {
+		orig, moved := e.moved[dirn]
+		if !moved {
+			continue
+		}
+		relField := fmt.Sprintf("%v_%s", dirn, e.Entry.Class)
+		script = append(script,
+			fmt.Sprintf("UPDATE %s REMOVE %s = %s", orig, relField, e.Entry.Rid),
+			fmt.Sprintf("UPDATE %s ADD %s = %s", e.vertex[dirn], relField, e.Entry.Rid))
+		extraSet = append(extraSet, fmt.Sprintf("%v = %s", dirn, e.vertex[dirn]))
+	}
+	script = append(script, "LET updated = "+updateCommand(&e.Entry, extraSet), "RETURN $updated")
+	resp, err := c.batch(true, script)
+	if err != nil {
+		return err
+	}
+	chill := chillson.Son{resp}
+	version, err := chill.GetInt("[0][value]")
+	if err != nil {
+		return errors.New(fmt.Sprintf("UpdateEdge: unexpected result of moving edge %s: %v", e.Entry.Rid, err))
+	}
+	e.Entry.Version = version
+	for dirn, orig := range e.moved {
+		if vtx, present := c.vertexes[orig]; present {
+			if rels := removeRel(vtx.edges[dirn][e.Entry.Class], e.Entry.Rid); len(rels) == 0 {
+				delete(vtx.edges[dirn], e.Entry.Class)
+			} else {
+				vtx.edges[dirn][e.Entry.Class] = rels
+			}
+			c.touch(orig)
+		}
+		if vtx, present := c.vertexes[e.vertex[dirn]]; present {
+			vtx.edges[dirn][e.Entry.Class] = append(vtx.edges[dirn][e.Entry.Class], vtxRel{e.Entry.Rid})
+			c.touch(e.vertex[dirn])
+		}
+	}
+	c.touch(e.Entry.Rid)
+	e.moved = make(map[EdgeDirection]string)
+	e.Entry.diff = nil
+	markSynced(&e.Entry)
+	return nil
+}
+
+/* mutateEntry performs atomic UPDATE operation given in clause (e.g. "INCREMENT count = 1") on the server and
+refreshes Version and value of the affected field from the record returned by the database. */
+func (c *Connection) mutateEntry(entry *Doc, field, clause string) error {
+	if (*entry).Rid == "" {
+		return errors.New("Update: entity has no associated RID, did it come from the db?")
+	}
+	resp, err := (*c).Command(fmt.Sprintf("UPDATE %s %s RETURN AFTER", (*entry).Rid, clause))
+	if err != nil {
+		return err
+	}
+	chill := chillson.Son{resp}
+	(*entry).Version, err = chill.GetInt("[0][@version]")
+	if err != nil {
+		return err
+	}
+	c.touch((*entry).Rid)
+	val, err := chill.Get("[0][" + field + "]")
+	if err == nil {
+		(*entry).propsContainer[field] = val
+		if (*entry).synced != nil {
+			(*entry).synced[field] = deepCopy(val)
+		}
+	} else {
+		delete((*entry).propsContainer, field)
+		delete((*entry).synced, field)
+	}
+	var kept []string // the field now holds the server value, so local changes to it are gone
+	for _, label := range (*entry).diff {
+		if label != field && !strings.HasPrefix(label, field+".") {
+			kept = append(kept, label)
+		}
+	}
+	(*entry).diff = kept
+	return nil
+}
+
+// Increment atomically adds n (which can be negative) to a numeric property of the Vertex or Edge entry.
+func (c *Connection) Increment(entry *Doc, field string, n interface{}) error {
+	return c.mutateEntry(entry, field, fmt.Sprintf("INCREMENT %s = %s", field, toOdbRepr(n)))
+}
+
+// AddToCollection atomically appends values to a list or set property of the Vertex or Edge entry.
+func (c *Connection) AddToCollection(entry *Doc, field string, vals ...interface{}) error {
+	if len(vals) == 0 {
+		return errors.New("AddToCollection: no values given")
+	}
+	var clauses []string
+	for _, val := range vals {
+		clauses = append(clauses, fmt.Sprintf("%s = %s", field, toOdbRepr(val)))
+	}
+	return c.mutateEntry(entry, field, "ADD "+strings.Join(clauses, ", "))
+}
+
+// RemoveFromCollection atomically removes values from a list or set property of the Vertex or Edge entry.
+func (c *Connection) RemoveFromCollection(entry *Doc, field string, vals ...interface{}) error {
+	if len(vals) == 0 {
+		return errors.New("RemoveFromCollection: no values given")
+	}
+	var clauses []string
+	for _, val := range vals {
+		clauses = append(clauses, fmt.Sprintf("%s = %s", field, toOdbRepr(val)))
+	}
+	return c.mutateEntry(entry, field, "REMOVE "+strings.Join(clauses, ", "))
+}
+
+// PutInMap atomically sets key of a map property of the Vertex or Edge entry.
+func (c *Connection) PutInMap(entry *Doc, field, key string, val interface{}) error {
+	return c.mutateEntry(entry, field, fmt.Sprintf("PUT %s = %s, %s", field, toOdbRepr(key), toOdbRepr(val)))
+}
+
+// RemoveFromMap atomically deletes key from a map property of the Vertex or Edge entry.
+func (c *Connection) RemoveFromMap(entry *Doc, field, key string) error {
+	return c.mutateEntry(entry, field, fmt.Sprintf("REMOVE %s = %s", field, toOdbRepr(key)))
+}
+
+/* UpdateEdge updates properties of an edge which were changed with SetProps(), SetPath() or RemoveProps() since the
+last sync with database. Each changed field is sent once. Ends of the edge changed with SetFrom() or SetTo() are
+applied too, along with relations of the old and new vertexes. Note it silently returns when no changes to the edge
+were made. List of changes won't be cleared if any error will be encountered. */
 func (c *Connection) UpdateEdge(e *Edge) error {
+	if err := c.validate(&e.Entry, false, true); err != nil {
+		return err
+	}
+	if len(e.moved) != 0 {
+		return c.moveEdge(e)
+	}
 	return c.updateEntry(&e.Entry)
 }
 
-/* UpdateVertex updates properties of a vertex which were changed with SetProp() function since the last sync with
-database. Note it silently returns when no changes to the vertex were made. List of changes won't be cleared if any
-error will be encountered. */
+/* UpdateVertex updates properties of a vertex which were changed with SetProps(), SetPath() or RemoveProps() since the
+last sync with database. Each changed field is sent once. Note it silently returns when no changes to the vertex were
+made. List of changes won't be cleared if any error will be encountered. */
 func (c *Connection) UpdateVertex(v *Vertex) error {
+	if err := c.validate(&v.Entry, false, false); err != nil {
+		return err
+	}
 	return c.updateEntry(&v.Entry)
 }
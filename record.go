@@ -0,0 +1,210 @@
+package sheikh
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+/* Exchange is a request sent to the server along with its response, as stored in recordings. URL holds just the
+path and query, so recordings don't depend on the server address. */
+type Exchange struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	SQL      string      `json:"sql,omitempty"` // decoded command text or batch script, one statement per line
+	Body     string      `json:"body,omitempty"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"` // response headers, except Date
+	Response string      `json:"response"`
+}
+
+// Recording is the content of a file written by Connection.Record.
+type Recording struct {
+	Database  string     `json:"database"`
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadRecording reads recording from the file written by Connection.Record.
+func LoadRecording(path string) (*Recording, error) {
+	buff, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err = json.Unmarshal(buff, &rec); err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadRecording: %s: %v", path, err))
+	}
+	return &rec, nil
+}
+
+/* ExchangeSQL returns SQL sent in the request: command text for /command requests (given in the path or, in
+OrientDB 3.x dialect, in the body) and statements of the script, one per line, for /batch requests. It's empty for
+other requests. */
+func ExchangeSQL(req *http.Request, body []byte) string {
+	parts := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	switch {
+	case len(parts) >= 4 && parts[0] == "command" && parts[2] == "sql":
+		text, _ := url.QueryUnescape(parts[3])
+		return text
+	case len(parts) == 3 && parts[0] == "command" && parts[2] == "sql": // OrientDB 3.x sends command in the body
+		var command struct {
+			Command string `json:"command"`
+		}
+		json.Unmarshal(body, &command)
+		return command.Command
+	case len(parts) >= 2 && parts[0] == "batch":
+		var batch struct {
+			Operations []struct {
+				Script interface{} `json:"script"`
+			} `json:"operations"`
+		}
+		json.Unmarshal(body, &batch)
+		var lines []string
+		for _, op := range batch.Operations {
+			switch script := op.Script.(type) {
+			case string:
+				lines = append(lines, script)
+			case []interface{}:
+				for _, stmt := range script {
+					lines = append(lines, fmt.Sprint(stmt))
+				}
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+// recorder is http.RoundTripper passing requests to the next one and saving them with responses to a file.
+type recorder struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	file      *os.File
+	exchanges int // number of exchanges saved so far
+}
+
+// recordingEnd closes the list of exchanges and the recording; new exchanges are written in its place.
+const recordingEnd = "\n  ]\n}\n"
+
+// startRecording creates the file of the recording with no exchanges yet.
+func startRecording(path, database string) (*recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := json.Marshal(database)
+	if _, err = fmt.Fprintf(file, "{\n  \"database\": %s,\n  \"exchanges\": [%s", name, recordingEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &recorder{file: file}, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	next := r.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Content-Encoding") == "gzip" { // store readable body, as it's served by replays
+		gz, err := gzip.NewReader(bytes.NewReader(respBody))
+		if err != nil {
+			return nil, err
+		}
+		if respBody, err = io.ReadAll(gz); err != nil {
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = int64(len(respBody))
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	header := resp.Header.Clone()
+	header.Del("Date")
+	ex := Exchange{Method: req.Method, URL: req.URL.RequestURI(), SQL: ExchangeSQL(req, body), Body: string(body),
+		Status: resp.StatusCode, Header: header, Response: string(respBody)}
+	if err = r.save(ex); err != nil {
+		return nil, errors.New(fmt.Sprintf("Record: saving %s %s: %v", req.Method, req.URL.Path, err))
+	}
+	return resp, nil
+}
+
+/* save appends the exchange to the file, writing it over the end of the recording, which follows it then. So the file
+is complete whenever recording stops. */
+func (r *recorder) save(ex Exchange) error {
+	buff, err := json.MarshalIndent(ex, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sep := ",\n    "
+	if r.exchanges == 0 {
+		sep = "\n    "
+	}
+	if _, err = r.file.Seek(-int64(len(recordingEnd)), io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err = r.file.WriteString(sep + string(buff) + recordingEnd); err != nil {
+		return err
+	}
+	r.exchanges++
+	return nil
+}
+
+// close stops recording, closing the file.
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+/* Record makes the connection save every request and response (see Exchange) to the file at path, which is
+overwritten. It should be called before Connect, so the recording can be replayed from the start (see sheikhtest
+package). Each exchange is appended to the file as soon as its response arrives, so the file is valid at any time.
+Pass empty path to stop recording and close the file. */
+func (c *Connection) Record(path string) error {
+	if r, recording := (*c).Client.Transport.(*recorder); recording {
+		(*c).Client.Transport = r.next
+		if err := r.close(); err != nil {
+			return err
+		}
+	}
+	if path == "" {
+		return nil
+	}
+	r, err := startRecording(path, (*c).Database)
+	if err != nil {
+		return err
+	}
+	r.next = (*c).Client.Transport
+	(*c).Client.Transport = r
+	return nil
+}
@@ -0,0 +1,546 @@
+package sheikh
+
+import (
+	"chillson"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Class describes OrientDB class, such as vertex or edge type.
+type Class struct {
+	Name         string     `json:"name"`
+	SuperClasses []string   `json:"superClasses,omitempty"` // e.g. V for vertex classes and E for edge classes
+	Abstract     bool       `json:"abstract,omitempty"`
+	Clusters     []int      `json:"clusters,omitempty"` // IDs of clusters storing records of the class; assigned by the server when empty
+	Properties   []Property `json:"properties,omitempty"`
+}
+
+/* Property describes property of a class along with its constraints. Zero values of constraint fields mean that the
+constraint is not set. */
+type Property struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`                  // OrientDB type, e.g. STRING, INTEGER, LINK, EMBEDDEDLIST
+	LinkedClass string `json:"linkedClass,omitempty"` // class of linked records, for LINK* and EMBEDDED* types
+	LinkedType  string `json:"linkedType,omitempty"`  // type of elements of embedded collections, e.g. STRING for EMBEDDEDLIST of strings
+	Mandatory   bool   `json:"mandatory,omitempty"`
+	NotNull     bool   `json:"notNull,omitempty"`
+	ReadOnly    bool   `json:"readonly,omitempty"`
+	Min         string `json:"min,omitempty"` // given verbatim, as they can be numbers, lengths or dates depending on the type
+	Max         string `json:"max,omitempty"`
+	Regexp      string `json:"regexp,omitempty"`
+	Default     string `json:"default,omitempty"` // given verbatim, so it can be an expression like sysdate()
+}
+
+// Index describes index on one or more properties of a class.
+type Index struct {
+	Name   string   `json:"name,omitempty"` // generated from class and fields when empty
+	Class  string   `json:"class"`
+	Type   string   `json:"type"` // UNIQUE, NOTUNIQUE, FULLTEXT, DICTIONARY, UNIQUE_HASH_INDEX etc.
+	Fields []string `json:"fields"`
+}
+
+/* Schema describes classes and indexes of the database. It can be read from the server with Connection.Schema, or
+defined in Go or JSON (see LoadSchema) and applied to the database with Apply. */
+type Schema struct {
+	Classes []Class `json:"classes"`
+	Indexes []Index `json:"indexes,omitempty"`
+}
+
+// Class returns description of the class of given name, or nil if there is no such class in the schema.
+func (s *Schema) Class(name string) *Class {
+	for ind := range s.Classes {
+		if s.Classes[ind].Name == name {
+			return &s.Classes[ind]
+		}
+	}
+	return nil
+}
+
+/* Ancestors returns inheritance chain of the class: its superclasses, their superclasses and so on (each listed once,
+nearest first). */
+func (s *Schema) Ancestors(name string) []string {
+	var ret []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) != 0 {
+		cl := s.Class(queue[0])
+		queue = queue[1:]
+		if cl == nil {
+			continue
+		}
+		for _, super := range cl.SuperClasses {
+			if !seen[super] {
+				seen[super] = true
+				ret = append(ret, super)
+				queue = append(queue, super)
+			}
+		}
+	}
+	return ret
+}
+
+// IsA tells whether the class is the ancestor class or inherits from it, e.g. IsA("Gopher", "V") for vertex classes.
+func (s *Schema) IsA(name, ancestor string) bool {
+	if name == ancestor {
+		return true
+	}
+	for _, super := range s.Ancestors(name) {
+		if super == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+/* AllProperties returns properties of the class, including ones inherited from its superclasses. Properties redefined
+in the class take precedence over inherited ones. */
+func (s *Schema) AllProperties(name string) []Property {
+	var ret []Property
+	seen := make(map[string]bool)
+	for _, clName := range append([]string{name}, s.Ancestors(name)...) {
+		cl := s.Class(clName)
+		if cl == nil {
+			continue
+		}
+		for _, p := range cl.Properties {
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret
+}
+
+// ClassIndexes returns indexes defined on the class.
+func (s *Schema) ClassIndexes(name string) []Index {
+	var ret []Index
+	for _, idx := range s.Indexes {
+		if idx.Class == name {
+			ret = append(ret, idx)
+		}
+	}
+	return ret
+}
+
+/* Schema reads classes (with their properties) and indexes of the database from the server. Builtin classes, such as
+V, E or OUser, are included. */
+func (c *Connection) Schema() (*Schema, error) {
+	respJson, err := c.transport().Database(c)
+	if err != nil {
+		return nil, err
+	}
+	chill := chillson.Son{respJson}
+	rawClasses, err := chill.GetArr("[classes]")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Schema: unable to extract classes from server response: %v", respJson))
+	}
+	s := new(Schema)
+	for _, rawClass := range rawClasses {
+		cl, indexes, err := unpackClass(rawClass)
+		if err != nil {
+			return nil, errors.New("Schema: " + err.Error())
+		}
+		s.Classes = append(s.Classes, cl)
+		s.Indexes = append(s.Indexes, indexes...)
+	}
+	return s, nil
+}
+
+// optStr returns value under path as a string, or "" if it's missing or null.
+func optStr(chill chillson.Son, path string) string {
+	val, err := chill.Get(path)
+	if err != nil || val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+// unpackClass reads class description, as returned by the /database server command.
+func unpackClass(rawClass interface{}) (cl Class, indexes []Index, err error) {
+	chill := chillson.Son{rawClass}
+	if cl.Name, err = chill.GetStr("[name]"); err != nil {
+		return cl, nil, errors.New(fmt.Sprintf("class without name: %v", rawClass))
+	}
+	if supers, err := chill.GetArr("[superClasses]"); err == nil {
+		for _, super := range supers {
+			cl.SuperClasses = append(cl.SuperClasses, fmt.Sprint(super))
+		}
+	} else if super := optStr(chill, "[superClass]"); super != "" { // older servers
+		cl.SuperClasses = []string{super}
+	}
+	cl.Abstract, _ = chill.GetBool("[abstract]")
+	clusters, _ := chill.GetArr("[clusters]")
+	for ind := range clusters {
+		id, err := chill.GetInt(fmt.Sprintf("[clusters][%v]", ind))
+		if err != nil {
+			return cl, nil, errors.New(fmt.Sprintf("class %s: bad cluster ID %v", cl.Name, clusters[ind]))
+		}
+		cl.Clusters = append(cl.Clusters, id)
+	}
+	props, _ := chill.GetArr("[properties]")
+	for _, rawProp := range props {
+		propChill := chillson.Son{rawProp}
+		var p Property
+		if p.Name, err = propChill.GetStr("[name]"); err != nil {
+			return cl, nil, errors.New(fmt.Sprintf("class %s: property without name: %v", cl.Name, rawProp))
+		}
+		p.Type = optStr(propChill, "[type]")
+		p.LinkedClass = optStr(propChill, "[linkedClass]")
+		p.LinkedType = optStr(propChill, "[linkedType]")
+		p.Mandatory, _ = propChill.GetBool("[mandatory]")
+		p.NotNull, _ = propChill.GetBool("[notNull]")
+		p.ReadOnly, _ = propChill.GetBool("[readonly]")
+		p.Min = optStr(propChill, "[min]")
+		p.Max = optStr(propChill, "[max]")
+		p.Regexp = optStr(propChill, "[regexp]")
+		p.Default = optStr(propChill, "[defaultValue]")
+		cl.Properties = append(cl.Properties, p)
+	}
+	rawIndexes, _ := chill.GetArr("[indexes]")
+	for _, rawIdx := range rawIndexes {
+		idxChill := chillson.Son{rawIdx}
+		idx := Index{Class: cl.Name}
+		if idx.Name, err = idxChill.GetStr("[name]"); err != nil {
+			return cl, nil, errors.New(fmt.Sprintf("class %s: index without name: %v", cl.Name, rawIdx))
+		}
+		idx.Type = optStr(idxChill, "[type]")
+		fields, _ := idxChill.GetArr("[fields]")
+		for _, field := range fields {
+			idx.Fields = append(idx.Fields, fmt.Sprint(field))
+		}
+		indexes = append(indexes, idx)
+	}
+	return cl, indexes, nil
+}
+
+// execAll performs commands one by one (schema changes can't be done in transactions), stopping on the first error.
+func (c *Connection) execAll(comTexts []string) error {
+	for _, comText := range comTexts {
+		if _, err := (*c).Command(comText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ifNotExistsClause(ifNotExists bool) string {
+	if ifNotExists {
+		return " IF NOT EXISTS"
+	}
+	return ""
+}
+
+// createClassCommands renders commands creating the class and its properties.
+func createClassCommands(cl Class, ifNotExists bool) []string {
+	comText := "CREATE CLASS " + cl.Name + ifNotExistsClause(ifNotExists)
+	if len(cl.SuperClasses) != 0 {
+		comText += " EXTENDS " + strings.Join(cl.SuperClasses, ", ")
+	}
+	if len(cl.Clusters) != 0 {
+		var ids []string
+		for _, id := range cl.Clusters {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		comText += " CLUSTER " + strings.Join(ids, ",")
+	}
+	if cl.Abstract {
+		comText += " ABSTRACT"
+	}
+	ret := []string{comText}
+	for _, p := range cl.Properties {
+		ret = append(ret, createPropertyCommands(cl.Name, p, ifNotExists)...)
+	}
+	return ret
+}
+
+// createPropertyCommands renders commands creating the property and setting its constraints.
+func createPropertyCommands(class string, p Property, ifNotExists bool) []string {
+	comText := fmt.Sprintf("CREATE PROPERTY %s.%s%s %s", class, p.Name, ifNotExistsClause(ifNotExists), p.Type)
+	if p.LinkedClass != "" {
+		comText += " " + p.LinkedClass
+	} else if p.LinkedType != "" {
+		comText += " " + p.LinkedType
+	}
+	return append([]string{comText}, alterPropertyCommands(class, p, Property{})...)
+}
+
+/* alterPropertyCommands renders commands changing constraints of the property from these of old (as reported by the
+server) to these of p. */
+func alterPropertyCommands(class string, p, old Property) []string {
+	var ret []string
+	alter := func(attr, val string) {
+		ret = append(ret, fmt.Sprintf("ALTER PROPERTY %s.%s %s %s", class, p.Name, attr, val))
+	}
+	if p.Mandatory != old.Mandatory {
+		alter("MANDATORY", fmt.Sprint(p.Mandatory))
+	}
+	if p.NotNull != old.NotNull {
+		alter("NOTNULL", fmt.Sprint(p.NotNull))
+	}
+	if p.ReadOnly != old.ReadOnly {
+		alter("READONLY", fmt.Sprint(p.ReadOnly))
+	}
+	if p.Min != old.Min {
+		alter("MIN", orNull(p.Min))
+	}
+	if p.Max != old.Max {
+		alter("MAX", orNull(p.Max))
+	}
+	if p.Regexp != old.Regexp {
+		if p.Regexp == "" {
+			alter("REGEXP", "null")
+		} else {
+			alter("REGEXP", toOdbRepr(p.Regexp))
+		}
+	}
+	if p.Default != old.Default {
+		alter("DEFAULT", orNull(p.Default))
+	}
+	return ret
+}
+
+func orNull(val string) string {
+	if val == "" {
+		return "null"
+	}
+	return val
+}
+
+// indexName returns name of the index, generating it (as the server does for single-field indexes) if it's empty.
+func indexName(idx Index) string {
+	if idx.Name != "" {
+		return idx.Name
+	}
+	if len(idx.Fields) == 1 {
+		return idx.Class + "." + idx.Fields[0]
+	}
+	return idx.Class + "_" + strings.Join(idx.Fields, "_")
+}
+
+func createIndexCommand(idx Index, ifNotExists bool) string {
+	return fmt.Sprintf("CREATE INDEX %s%s ON %s (%s) %s", indexName(idx), ifNotExistsClause(ifNotExists), idx.Class,
+		strings.Join(idx.Fields, ", "), idx.Type)
+}
+
+/* CreateClass creates the class along with properties listed in it. With ifNotExists, existing class or properties
+are not treated as an error. For example,
+   c.CreateClass(Class{Name: "Gopher", SuperClasses: []string{"V"}, Properties: []Property{{Name: "name", Type: "STRING"}}}, true)
+creates vertex class Gopher. */
+func (c *Connection) CreateClass(cl Class, ifNotExists bool) error {
+	if cl.Name == "" {
+		return errors.New("CreateClass: class has no name")
+	}
+	return c.execAll(createClassCommands(cl, ifNotExists))
+}
+
+/* AlterClass changes attribute of the class, e.g.
+   c.AlterClass("Gopher", "SUPERCLASS", "+Animal")
+Value is given verbatim. */
+func (c *Connection) AlterClass(name, attr, value string) error {
+	_, err := (*c).Command(fmt.Sprintf("ALTER CLASS %s %s %s", name, attr, value))
+	return err
+}
+
+/* DropClass removes the class from the schema. With ifExists, missing class is not treated as an error. Classes which
+have records (e.g. vertex classes with vertexes) can be only dropped with unsafe. */
+func (c *Connection) DropClass(name string, ifExists, unsafe bool) error {
+	comText := "DROP CLASS " + name
+	if ifExists {
+		comText += " IF EXISTS"
+	}
+	if unsafe {
+		comText += " UNSAFE"
+	}
+	_, err := (*c).Command(comText)
+	return err
+}
+
+/* CreateProperty creates property of the class and sets its constraints. With ifNotExists, existing property is not
+treated as an error; constraints given in p (ones with non-zero values) are set on it anyway, but the others are kept
+as they are, and so is its type. Schema.Apply makes the property match p exactly. */
+func (c *Connection) CreateProperty(class string, p Property, ifNotExists bool) error {
+	if p.Name == "" || p.Type == "" {
+		return errors.New(fmt.Sprintf("CreateProperty: property of %s needs name and type", class))
+	}
+	return c.execAll(createPropertyCommands(class, p, ifNotExists))
+}
+
+/* CreateIndex creates index on given fields of the class. With ifNotExists, existing index of the same name is not
+treated as an error. */
+func (c *Connection) CreateIndex(idx Index, ifNotExists bool) error {
+	if idx.Class == "" || len(idx.Fields) == 0 || idx.Type == "" {
+		return errors.New("CreateIndex: index needs class, fields and type")
+	}
+	_, err := (*c).Command(createIndexCommand(idx, ifNotExists))
+	return err
+}
+
+/* LoadSchema reads schema definition from JSON, e.g.
+   {"classes": [{"name": "Gopher", "superClasses": ["V"], "properties": [{"name": "name", "type": "STRING"}]}],
+    "indexes": [{"class": "Gopher", "type": "UNIQUE", "fields": ["name"]}]}
+Field names follow the ones reported by the server, except for default value of a property, which is given in default
+field rather than defaultValue. Only JSON is read; YAML definitions have to be converted to JSON first. */
+func LoadSchema(r io.Reader) (*Schema, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	s := new(Schema)
+	if err := dec.Decode(s); err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadSchema: %v", err))
+	}
+	for _, cl := range s.Classes {
+		if cl.Name == "" {
+			return nil, errors.New("LoadSchema: class without name")
+		}
+		for _, p := range cl.Properties {
+			if p.Name == "" || p.Type == "" {
+				return nil, errors.New(fmt.Sprintf("LoadSchema: property of %s needs name and type", cl.Name))
+			}
+		}
+	}
+	for _, idx := range s.Indexes {
+		if idx.Class == "" || len(idx.Fields) == 0 || idx.Type == "" {
+			return nil, errors.New(fmt.Sprintf("LoadSchema: index %s needs class, fields and type", indexName(idx)))
+		}
+	}
+	return s, nil
+}
+
+/* Plan returns commands which would bring the database schema to the one described by s, without running them (it's
+a dry run of Apply). Classes, properties and indexes which aren't described by s are left alone. */
+func (s *Schema) Plan(c *Connection) ([]string, error) {
+	current, err := c.Schema()
+	if err != nil {
+		return nil, err
+	}
+	return s.planChanges(current), nil
+}
+
+/* Apply runs commands needed to bring the database schema to the one described by s, and returns them. If there is
+nothing to change, no commands are run, so it's safe to call Apply on every start of the application. */
+func (s *Schema) Apply(c *Connection) ([]string, error) {
+	plan, err := s.Plan(c)
+	if err != nil {
+		return nil, err
+	}
+	return plan, c.execAll(plan)
+}
+
+// planChanges renders commands transforming current schema into s.
+func (s *Schema) planChanges(current *Schema) []string {
+	var ret []string
+	for _, cl := range s.orderedClasses() {
+		curCl := current.Class(cl.Name)
+		if curCl == nil {
+			ret = append(ret, createClassCommands(cl, false)...)
+			continue
+		}
+		if !sameSet(cl.SuperClasses, curCl.SuperClasses) {
+			ret = append(ret, fmt.Sprintf("ALTER CLASS %s SUPERCLASSES %s", cl.Name, orNull(strings.Join(cl.SuperClasses, ", "))))
+		}
+		if cl.Abstract != curCl.Abstract {
+			ret = append(ret, fmt.Sprintf("ALTER CLASS %s ABSTRACT %v", cl.Name, cl.Abstract))
+		}
+		for _, id := range cl.Clusters {
+			if !containsInt(curCl.Clusters, id) {
+				ret = append(ret, fmt.Sprintf("ALTER CLASS %s ADDCLUSTER %v", cl.Name, id))
+			}
+		}
+		for _, p := range cl.Properties {
+			ret = append(ret, planProperty(cl.Name, p, curCl.Properties)...)
+		}
+	}
+	for _, idx := range s.Indexes {
+		name := indexName(idx)
+		var curIdx *Index
+		for ind := range current.Indexes {
+			if current.Indexes[ind].Name == name {
+				curIdx = &current.Indexes[ind]
+			}
+		}
+		if curIdx != nil && strings.EqualFold(curIdx.Type, idx.Type) && curIdx.Class == idx.Class &&
+			strings.Join(curIdx.Fields, ",") == strings.Join(idx.Fields, ",") {
+			continue
+		}
+		if curIdx != nil {
+			ret = append(ret, "DROP INDEX "+name)
+		}
+		ret = append(ret, createIndexCommand(idx, false))
+	}
+	return ret
+}
+
+// planProperty renders commands creating property p of the class, or changing it from one of current properties.
+func planProperty(class string, p Property, current []Property) []string {
+	var curP *Property
+	for ind := range current {
+		if current[ind].Name == p.Name {
+			curP = &current[ind]
+		}
+	}
+	if curP == nil {
+		return createPropertyCommands(class, p, false)
+	}
+	var ret []string
+	if !strings.EqualFold(p.Type, curP.Type) {
+		ret = append(ret, fmt.Sprintf("ALTER PROPERTY %s.%s TYPE %s", class, p.Name, p.Type))
+	}
+	if p.LinkedClass != curP.LinkedClass {
+		ret = append(ret, fmt.Sprintf("ALTER PROPERTY %s.%s LINKEDCLASS %s", class, p.Name, orNull(p.LinkedClass)))
+	}
+	if !strings.EqualFold(p.LinkedType, curP.LinkedType) {
+		ret = append(ret, fmt.Sprintf("ALTER PROPERTY %s.%s LINKEDTYPE %s", class, p.Name, orNull(p.LinkedType)))
+	}
+	return append(ret, alterPropertyCommands(class, p, *curP)...)
+}
+
+// orderedClasses returns classes of s, with superclasses described in s placed before their subclasses.
+func (s *Schema) orderedClasses() []Class {
+	var ret []Class
+	placed := make(map[string]bool)
+	var place func(cl Class)
+	place = func(cl Class) {
+		if placed[cl.Name] {
+			return
+		}
+		placed[cl.Name] = true
+		for _, super := range cl.SuperClasses {
+			if superCl := s.Class(super); superCl != nil {
+				place(*superCl)
+			}
+		}
+		ret = append(ret, cl)
+	}
+	for _, cl := range s.Classes {
+		place(cl)
+	}
+	return ret
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			found = found || x == y
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsInt(list []int, x int) bool {
+	for _, y := range list {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
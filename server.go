@@ -0,0 +1,113 @@
+package sheikh
+
+import (
+	"chillson"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+/* serverRequest sends request to server-level endpoint of the REST API, authorizing it with ServerUsername and
+ServerPassword. It uses a copy of Client without cookies, so the database session isn't mixed with server login.
+REST API on Port is used even if the connection uses binary protocol, which doesn't implement server administration.
+Decoded JSON response is returned, or nil if the body is empty. */
+func (c *Connection) serverRequest(method, path string) (interface{}, error) {
+	req, err := http.NewRequest(method, c.restURL(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	if (*c).ServerUsername != "" {
+		req.SetBasicAuth((*c).ServerUsername, (*c).ServerPassword)
+	}
+	client := (*c).Client
+	client.Jar = nil
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	buff, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(fmt.Sprintf("%s /%s: HTTP status %v, server response: %q", method, path, resp.Status,
+			buff))
+	}
+	if len(buff) == 0 {
+		return nil, nil
+	}
+	return decodeJson(buff)
+}
+
+// ListDatabases returns names of databases available on the server.
+func (c *Connection) ListDatabases() ([]string, error) {
+	respJson, err := c.serverRequest("GET", "listDatabases")
+	if err != nil {
+		return nil, err
+	}
+	chill := chillson.Son{respJson}
+	rawNames, err := chill.GetArr("[databases]")
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ListDatabases: unexpected server response: %v", respJson))
+	}
+	var ret []string
+	for ind := range rawNames {
+		name, err := chill.GetStr(fmt.Sprintf("[databases][%v]", ind))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+// DatabaseExists tells whether database of given name is available on the server.
+func (c *Connection) DatabaseExists(name string) (bool, error) {
+	names, err := c.ListDatabases()
+	if err != nil {
+		return false, err
+	}
+	for _, dbName := range names {
+		if dbName == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/* CreateDatabase creates a new database on the server. Storage is plocal (persistent) or memory, and dbType is
+graph or document. It requires server credentials, e.g.
+   c.ServerUsername, c.ServerPassword = "root", "secret"
+   err := c.CreateDatabase("Scratch", "memory", "graph") */
+func (c *Connection) CreateDatabase(name, storage, dbType string) error {
+	_, err := c.serverRequest("POST", fmt.Sprintf("database/%s/%s/%s", name, storage, dbType))
+	if err != nil {
+		return errors.New("CreateDatabase: " + err.Error())
+	}
+	return nil
+}
+
+// DropDatabase removes the database from the server; it requires server credentials.
+func (c *Connection) DropDatabase(name string) error {
+	_, err := c.serverRequest("DELETE", "database/"+name)
+	if err != nil {
+		return errors.New("DropDatabase: " + err.Error())
+	}
+	return nil
+}
+
+/* ServerInfo returns information about the server, such as open connections, databases, storages and configuration
+properties, as decoded from JSON. It requires server credentials. */
+func (c *Connection) ServerInfo() (map[string]interface{}, error) {
+	respJson, err := c.serverRequest("GET", "server")
+	if err != nil {
+		return nil, errors.New("ServerInfo: " + err.Error())
+	}
+	info, ok := respJson.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("ServerInfo: unexpected server response: %v", respJson))
+	}
+	return info, nil
+}
@@ -0,0 +1,704 @@
+package sheikhtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sheikh"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* FakeServer is an in-process stand-in for OrientDB REST API, keeping one graph database in memory. It implements
+endpoints used by the driver: /connect (with OSESSIONID cookie), /command with a subset of SQL (CREATE VERTEX/EDGE,
+INSERT, SELECT ... WHERE with bothE() and unionall() functions, UPDATE with SET/REMOVE/INCREMENT/ADD/PUT/MERGE and
+RETURN, DELETE, LET/RETURN in scripts and basic schema commands), /batch, /document, /database and /listDatabases.
+Property constraints and unique indexes are enforced. */
+type FakeServer struct {
+	*httptest.Server
+	Database, Username, Password string
+	/* Version of OrientDB reported in Server header and by /database endpoint. With 3.x version, results of
+	   commands are given in the shape of OrientDB 3.x. */
+	Version string
+
+	mu          sync.Mutex
+	db          *fakeDB
+	sessions    map[string]bool
+	binary      net.Listener // serving binary protocol, started by ListenBinary
+	binaryConns map[net.Conn]bool
+	// Number of tree-based RidBag changes reported after RECORD_CREATE/UPDATE (the fake doesn't make any).
+	binaryChanges int
+}
+
+/* NewFakeServer starts a fake server with empty database of given name, accessible with given login. It should be
+closed with Close when it's not needed anymore. */
+func NewFakeServer(database, user, pass string) *FakeServer {
+	fs := &FakeServer{Database: database, Username: user, Password: pass, Version: "2.2.fake", db: newFakeDB(),
+		sessions: make(map[string]bool)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serveHTTP))
+	return fs
+}
+
+// Connection returns new connection to the fake server; it still has to be initialized with Connect.
+func (fs *FakeServer) Connection() sheikh.Connection {
+	addr, _ := url.Parse(fs.URL)
+	host, port, _ := net.SplitHostPort(addr.Host)
+	c := sheikh.NewConnection(host, fs.Database, fs.Username, fs.Password)
+	c.Port = port
+	return c
+}
+
+/* NewFake starts a fake server for the test, runs fixtures on its database and returns connection to it. The server
+is closed when the test finishes. Unlike New, it doesn't need a running OrientDB. */
+func NewFake(t testing.TB, fixtures ...Fixture) *sheikh.Connection {
+	t.Helper()
+	fs := NewFakeServer("fake", Username, Password)
+	t.Cleanup(fs.Close)
+	c := fs.Connection()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("sheikhtest: %v", err)
+	}
+	for ind, fixture := range fixtures {
+		if err := fixture(&c); err != nil {
+			t.Fatalf("sheikhtest: fixture %v failed: %v", ind, err)
+		}
+	}
+	return &c
+}
+
+// fakeError is an error reported by the fake database with given HTTP status.
+type fakeError struct {
+	status int
+	msg    string
+}
+
+func (fe fakeError) Error() string {
+	return fe.msg
+}
+
+func errorf(format string, a ...interface{}) error {
+	return fakeError{http.StatusInternalServerError, fmt.Sprintf(format, a...)}
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	buff, err := json.Marshal(body)
+	if err != nil {
+		status, buff = http.StatusInternalServerError, []byte(`{"errors":[{"code":500,"reason":500,"content":"cannot encode response"}]}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buff)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if fe, ok := err.(fakeError); ok {
+		status = fe.status
+	}
+	writeJson(w, status, map[string]interface{}{
+		"errors": []interface{}{map[string]interface{}{"code": status, "reason": status, "content": err.Error()}},
+	})
+}
+
+// authorized tells whether the request has a valid session cookie or correct basic auth credentials.
+func (fs *FakeServer) authorized(r *http.Request) bool {
+	if user, pass, ok := r.BasicAuth(); ok {
+		return user == fs.Username && pass == fs.Password
+	}
+	cookie, err := r.Cookie("OSESSIONID")
+	return err == nil && fs.sessions[cookie.Value]
+}
+
+func (fs *FakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	w.Header().Set("Server", "OrientDB Server v."+fs.Version)
+	// The path is split before unescaping, as SQL text may contain escaped slashes.
+	rawParts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	var parts []string
+	for _, part := range rawParts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, fakeError{http.StatusBadRequest, "bad path"})
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+	if parts[0] == "listDatabases" {
+		writeJson(w, http.StatusOK, map[string]interface{}{"@type": "d", "databases": []string{fs.Database}})
+		return
+	}
+	if len(parts) < 2 {
+		writeError(w, fakeError{http.StatusNotFound, "unknown endpoint " + r.URL.Path})
+		return
+	}
+	if parts[1] != fs.Database {
+		writeError(w, fakeError{http.StatusUnauthorized, "database " + parts[1] + " doesn't exist"})
+		return
+	}
+	if parts[0] == "connect" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != fs.Username || pass != fs.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="OrientDB db-`+fs.Database+`"`)
+			writeError(w, fakeError{http.StatusUnauthorized, "wrong user name or password"})
+			return
+		}
+		buff := make([]byte, 16)
+		rand.Read(buff)
+		session := hex.EncodeToString(buff)
+		fs.sessions[session] = true
+		http.SetCookie(w, &http.Cookie{Name: "OSESSIONID", Value: session, Path: "/"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !fs.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="OrientDB db-`+fs.Database+`"`)
+		writeError(w, fakeError{http.StatusUnauthorized, "session expired or missing"})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, fakeError{http.StatusBadRequest, err.Error()})
+		return
+	}
+
+	switch {
+	case parts[0] == "command" && len(parts) >= 4 && strings.EqualFold(parts[2], "sql"):
+		text, err := url.QueryUnescape(rawParts[3])
+		if err != nil {
+			writeError(w, fakeError{http.StatusBadRequest, err.Error()})
+			return
+		}
+		limit := -1
+		if len(parts) >= 5 {
+			limit, _ = strconv.Atoi(parts[4])
+		}
+		res, err := fs.db.run([]string{text}, false, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"result": fs.result(res, text)})
+	case parts[0] == "command" && len(parts) == 3 && strings.EqualFold(parts[2], "sql") && r.Method == "POST":
+		fs.serveCommand(w, body)
+	case parts[0] == "batch" && r.Method == "POST":
+		fs.serveBatch(w, body)
+	case parts[0] == "database" && r.Method == "GET":
+		schema := fs.db.schemaJson()
+		schema["server"] = map[string]interface{}{"version": fs.Version}
+		writeJson(w, http.StatusOK, schema)
+	case parts[0] == "document":
+		fs.serveDocument(w, r.Method, parts[2:], body)
+	default:
+		writeError(w, fakeError{http.StatusNotFound, "unknown endpoint " + r.Method + " " + r.URL.Path})
+	}
+}
+
+func (fs *FakeServer) serveBatch(w http.ResponseWriter, body []byte) {
+	var batch struct {
+		Transaction bool
+		Operations  []struct {
+			Type, Language string
+			Script         interface{}
+		}
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeError(w, fakeError{http.StatusBadRequest, "bad batch: " + err.Error()})
+		return
+	}
+	var script []string
+	for _, op := range batch.Operations {
+		if op.Type != "script" || !strings.EqualFold(op.Language, "sql") {
+			writeError(w, fakeError{http.StatusBadRequest, "only SQL script operations are supported"})
+			return
+		}
+		switch t := op.Script.(type) {
+		case string:
+			script = append(script, strings.Split(t, ";")...)
+		case []interface{}:
+			for _, line := range t {
+				script = append(script, fmt.Sprint(line))
+			}
+		}
+	}
+	res, err := fs.db.run(script, batch.Transaction, -1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"result": fs.result(res, strings.Join(script, "\n"))})
+}
+
+// serveCommand runs command given in JSON body, as OrientDB 3.x REST API accepts it.
+func (fs *FakeServer) serveCommand(w http.ResponseWriter, body []byte) {
+	var command struct {
+		Command    string
+		Parameters interface{}
+		Limit      *int
+	}
+	if err := json.Unmarshal(body, &command); err != nil || command.Command == "" {
+		writeError(w, fakeError{http.StatusBadRequest, "bad command body"})
+		return
+	}
+	switch params := command.Parameters.(type) {
+	case map[string]interface{}:
+		if len(params) != 0 {
+			writeError(w, fakeError{http.StatusBadRequest, "command parameters are not supported"})
+			return
+		}
+	case []interface{}:
+		if len(params) != 0 {
+			writeError(w, fakeError{http.StatusBadRequest, "command parameters are not supported"})
+			return
+		}
+	}
+	limit := -1
+	if command.Limit != nil {
+		limit = *command.Limit
+	}
+	res, err := fs.db.run([]string{command.Command}, false, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{"result": fs.result(res, command.Command)})
+}
+
+// returnPattern matches RETURN clause of UPDATE statements, capturing the returned expression.
+var returnPattern = regexp.MustCompile(`(?i)\bRETURN\s+(?:BEFORE|AFTER)\s+(\S+)`)
+
+/* result renders result of the SQL text in the shape of server's version. OrientDB 3.x gives projections #-1:-1 RID,
+counts of changed records in count field and values returned by UPDATE named after the returned expression, e.g.
+{"@rid": "#9:1"} for RETURN BEFORE @rid; 2.x wraps the latter two in value field. */
+func (fs *FakeServer) result(res []interface{}, text string) []interface{} {
+	if major, _ := strconv.Atoi(strings.SplitN(fs.Version, ".", 2)[0]); major < 3 {
+		return res
+	}
+	name := "count"
+	if m := returnPattern.FindAllStringSubmatch(text, -1); m != nil {
+		name = m[len(m)-1][1]
+	}
+	ret := make([]interface{}, len(res))
+	for ind, rawRec := range res {
+		rec, ok := rawRec.(map[string]interface{})
+		if !ok {
+			ret[ind] = rawRec
+			continue
+		}
+		if val, ok := rec["value"]; ok && len(rec) == 3 && rec["@version"] == 0 {
+			rec = map[string]interface{}{"@type": "d", "@rid": "#-1:-1", "@version": 0, name: val}
+		} else if _, ok := rec["@rid"]; !ok {
+			rec = deepCopy(rec).(map[string]interface{})
+			rec["@rid"] = "#-1:-1"
+		}
+		ret[ind] = rec
+	}
+	return ret
+}
+
+func (fs *FakeServer) serveDocument(w http.ResponseWriter, method string, args []string, body []byte) {
+	var rid string
+	if len(args) != 0 {
+		rid = "#" + strings.TrimPrefix(args[0], "#")
+	}
+	var fields map[string]interface{}
+	if method == "POST" || method == "PUT" {
+		dec := json.NewDecoder(strings.NewReader(string(body)))
+		dec.UseNumber()
+		if err := dec.Decode(&fields); err != nil {
+			writeError(w, fakeError{http.StatusBadRequest, "bad document: " + err.Error()})
+			return
+		}
+		fields = normalize(fields).(map[string]interface{})
+	}
+	switch method {
+	case "GET":
+		rec := fs.db.records[rid]
+		if rec == nil {
+			writeError(w, fakeError{http.StatusNotFound, "record " + rid + " not found"})
+			return
+		}
+		writeJson(w, http.StatusOK, rec.toJson())
+	case "POST":
+		class, _ := fields["@class"].(string)
+		rec, err := fs.db.insert(class, stripMeta(fields))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, rec.toJson())
+	case "PUT":
+		rec := fs.db.records[rid]
+		if rec == nil {
+			writeError(w, fakeError{http.StatusNotFound, "record " + rid + " not found"})
+			return
+		}
+		if version, ok := fields["@version"].(float64); ok && int(version) != rec.version {
+			writeError(w, fakeError{http.StatusConflict, fmt.Sprintf(
+				"OConcurrentModificationException: record %s has version %v, your version is %v", rid, rec.version,
+				version)})
+			return
+		}
+		updated := rec.copy()
+		updated.fields = stripMeta(fields)
+		for name, val := range rec.fields { // graph fields are kept
+			if isGraphField(name) {
+				updated.fields[name] = val
+			}
+		}
+		if err := fs.db.save(updated); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, updated.toJson())
+	case "DELETE":
+		if fs.db.records[rid] == nil {
+			writeError(w, fakeError{http.StatusNotFound, "record " + rid + " not found"})
+			return
+		}
+		fs.db.remove(rid)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, fakeError{http.StatusMethodNotAllowed, method + " not supported"})
+	}
+}
+
+// fakeRecord is a document stored in the fake database; links are kept as RID strings.
+type fakeRecord struct {
+	class             string
+	cluster, position int
+	version           int
+	fields            map[string]interface{}
+}
+
+func (rec *fakeRecord) rid() string {
+	return fmt.Sprintf("#%v:%v", rec.cluster, rec.position)
+}
+
+func (rec *fakeRecord) copy() *fakeRecord {
+	ret := *rec
+	ret.fields = deepCopy(rec.fields).(map[string]interface{})
+	return &ret
+}
+
+// toJson renders the record as it's sent by the server.
+func (rec *fakeRecord) toJson() map[string]interface{} {
+	ret := deepCopy(rec.fields).(map[string]interface{})
+	ret["@type"], ret["@rid"], ret["@version"], ret["@class"] = "d", rec.rid(), rec.version, rec.class
+	return ret
+}
+
+type fakeClass struct {
+	sheikh.Class
+	cluster, nextPosition int
+}
+
+type fakeDB struct {
+	classes     map[string]*fakeClass // by lowercased name, as class names are case insensitive
+	records     map[string]*fakeRecord
+	indexes     []sheikh.Index
+	nextCluster int
+}
+
+func newFakeDB() *fakeDB {
+	db := &fakeDB{classes: make(map[string]*fakeClass), records: make(map[string]*fakeRecord), nextCluster: 9}
+	db.addClass(sheikh.Class{Name: "V"})
+	db.addClass(sheikh.Class{Name: "E"})
+	return db
+}
+
+func (db *fakeDB) addClass(cl sheikh.Class) *fakeClass {
+	fc := &fakeClass{Class: cl, cluster: db.nextCluster}
+	fc.Clusters = []int{fc.cluster}
+	db.nextCluster++
+	db.classes[strings.ToLower(cl.Name)] = fc
+	return fc
+}
+
+func (db *fakeDB) class(name string) *fakeClass {
+	return db.classes[strings.ToLower(name)]
+}
+
+// isA tells whether class is ancestor or is a subclass of it.
+func (db *fakeDB) isA(class, ancestor string) bool {
+	if strings.EqualFold(class, ancestor) {
+		return true
+	}
+	if fc := db.class(class); fc != nil {
+		for _, super := range fc.SuperClasses {
+			if db.isA(super, ancestor) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scan returns records of the class and its subclasses, ordered by RIDs.
+func (db *fakeDB) scan(class string) []*fakeRecord {
+	var ret []*fakeRecord
+	for _, rec := range db.records {
+		if db.isA(rec.class, class) {
+			ret = append(ret, rec)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].cluster != ret[j].cluster {
+			return ret[i].cluster < ret[j].cluster
+		}
+		return ret[i].position < ret[j].position
+	})
+	return ret
+}
+
+// insert stores new record of the class with given fields.
+func (db *fakeDB) insert(class string, fields map[string]interface{}) (*fakeRecord, error) {
+	fc := db.class(class)
+	if fc == nil {
+		return nil, errorf("Class '%s' was not found", class)
+	}
+	if fc.Abstract {
+		return nil, errorf("Cannot create an instance of abstract class '%s'", fc.Name)
+	}
+	rec := &fakeRecord{class: fc.Name, cluster: fc.cluster, position: fc.nextPosition, version: 1, fields: fields}
+	if err := db.check(rec); err != nil {
+		return nil, err
+	}
+	fc.nextPosition++
+	db.records[rec.rid()] = rec
+	return rec, nil
+}
+
+// save replaces stored record with updated one, bumping its version.
+func (db *fakeDB) save(rec *fakeRecord) error {
+	if err := db.check(rec); err != nil {
+		return err
+	}
+	rec.version = db.records[rec.rid()].version + 1
+	db.records[rec.rid()] = rec
+	return nil
+}
+
+// remove deletes the record, along with its edges if it's a vertex, and its relations if it's an edge.
+func (db *fakeDB) remove(rid string) {
+	rec := db.records[rid]
+	if rec == nil {
+		return
+	}
+	delete(db.records, rid)
+	if db.isA(rec.class, "E") {
+		for _, end := range []string{"out", "in"} {
+			endRid, _ := rec.fields[end].(string)
+			if vtx := db.records[endRid]; vtx != nil {
+				vtx = vtx.copy()
+				removeFromRel(vtx.fields, end+"_"+relSuffix(rec.class), rid)
+				vtx.version++
+				db.records[endRid] = vtx
+			}
+		}
+		return
+	}
+	for name, val := range rec.fields {
+		if !strings.HasPrefix(name, "out_") && !strings.HasPrefix(name, "in_") {
+			continue
+		}
+		rels, _ := val.([]interface{})
+		for _, edgeRid := range rels {
+			db.remove(fmt.Sprint(edgeRid))
+		}
+	}
+}
+
+// relSuffix returns suffix of vertex fields listing edges of the class, e.g. out_owes.
+func relSuffix(edgeClass string) string {
+	if strings.EqualFold(edgeClass, "E") {
+		return ""
+	}
+	return edgeClass
+}
+
+func removeFromRel(fields map[string]interface{}, relField, edgeRid string) {
+	rels, _ := fields[relField].([]interface{})
+	var kept []interface{}
+	for _, rel := range rels {
+		if rel != edgeRid {
+			kept = append(kept, rel)
+		}
+	}
+	if len(kept) == 0 {
+		delete(fields, relField)
+	} else {
+		fields[relField] = kept
+	}
+}
+
+func isGraphField(name string) bool {
+	return name == "out" || name == "in" || strings.HasPrefix(name, "out_") || strings.HasPrefix(name, "in_")
+}
+
+// checkIndexes reports violation of unique indexes by the record.
+func (db *fakeDB) checkIndexes(rec *fakeRecord) error {
+	for _, idx := range db.indexes {
+		if !strings.HasPrefix(strings.ToUpper(idx.Type), "UNIQUE") || !db.isA(rec.class, idx.Class) {
+			continue
+		}
+		key, ok := indexKey(rec, idx)
+		if !ok {
+			continue
+		}
+		for _, other := range db.scan(idx.Class) {
+			if other.rid() == rec.rid() {
+				continue
+			}
+			if otherKey, ok := indexKey(other, idx); ok && otherKey == key {
+				return errorf("ORecordDuplicatedException: Cannot index record %s: found duplicated key '%s' in index '%s' previously assigned to the record %s",
+					rec.rid(), key, idx.Name, other.rid())
+			}
+		}
+	}
+	return nil
+}
+
+// indexKey returns key of the record in the index, or false if any of the indexed fields is null.
+func indexKey(rec *fakeRecord, idx sheikh.Index) (string, bool) {
+	var parts []string
+	for _, field := range idx.Fields {
+		val, present := rec.fields[field]
+		if !present || val == nil {
+			return "", false
+		}
+		parts = append(parts, repr(val))
+	}
+	return strings.Join(parts, ", "), true
+}
+
+// check validates the record against constraints of its class and unique indexes.
+func (db *fakeDB) check(rec *fakeRecord) error {
+	s := &sheikh.Schema{}
+	for _, fc := range db.classes {
+		s.Classes = append(s.Classes, fc.Class)
+	}
+	doc := sheikh.NewVertex(rec.class)
+	for name, val := range rec.fields {
+		doc.SetProps(name, val)
+	}
+	if errs := s.Validate(&doc.Entry, true, db.isA(rec.class, "E")); len(errs) != 0 {
+		return errorf("OValidationException: %v", errs)
+	}
+	return db.checkIndexes(rec)
+}
+
+// schemaJson renders the schema as /database endpoint does, except for server version.
+func (db *fakeDB) schemaJson() map[string]interface{} {
+	var names []string
+	for name := range db.classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var classes []interface{}
+	for _, name := range names {
+		fc := db.classes[name]
+		var props, indexes []interface{}
+		for _, p := range fc.Properties {
+			props = append(props, map[string]interface{}{
+				"name": p.Name, "type": p.Type, "linkedClass": nullable(p.LinkedClass),
+				"linkedType": nullable(p.LinkedType), "mandatory": p.Mandatory, "notNull": p.NotNull,
+				"readonly": p.ReadOnly, "min": nullable(p.Min), "max": nullable(p.Max), "regexp": nullable(p.Regexp),
+				"defaultValue": nullable(p.Default),
+			})
+		}
+		for _, idx := range db.indexes {
+			if strings.EqualFold(idx.Class, fc.Name) {
+				indexes = append(indexes, map[string]interface{}{"name": idx.Name, "type": idx.Type, "fields": idx.Fields})
+			}
+		}
+		supers := append([]string{}, fc.SuperClasses...)
+		var super interface{}
+		if len(supers) != 0 {
+			super = supers[0]
+		}
+		classes = append(classes, map[string]interface{}{
+			"name": fc.Name, "superClass": super, "superClasses": supers, "abstract": fc.Abstract,
+			"clusters": fc.Clusters, "defaultCluster": fc.cluster, "properties": props, "indexes": indexes,
+		})
+	}
+	return map[string]interface{}{"classes": classes}
+}
+
+func nullable(val string) interface{} {
+	if val == "" {
+		return nil
+	}
+	return val
+}
+
+func stripMeta(fields map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for name, val := range fields {
+		if !strings.HasPrefix(name, "@") {
+			ret[name] = val
+		}
+	}
+	return ret
+}
+
+// snapshot returns deep copy of the database, to be restored when a transaction fails.
+func (db *fakeDB) snapshot() *fakeDB {
+	ret := &fakeDB{classes: make(map[string]*fakeClass), records: make(map[string]*fakeRecord),
+		indexes: append([]sheikh.Index{}, db.indexes...), nextCluster: db.nextCluster}
+	for name, fc := range db.classes {
+		fcCopy := *fc
+		fcCopy.Properties = append([]sheikh.Property{}, fc.Properties...)
+		ret.classes[name] = &fcCopy
+	}
+	for rid, rec := range db.records {
+		ret.records[rid] = rec.copy()
+	}
+	return ret
+}
+
+/* run executes SQL statements, sharing LET variables, and returns result of RETURN statement or the last statement.
+With transaction, changes are rolled back if any statement fails. Positive limit restricts number of returned
+records. */
+func (db *fakeDB) run(script []string, transaction bool, limit int) ([]interface{}, error) {
+	var saved *fakeDB
+	if transaction {
+		saved = db.snapshot()
+	}
+	vars := make(map[string][]interface{})
+	var res []interface{}
+	for _, text := range script {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		var returned bool
+		var err error
+		res, returned, err = db.exec(text, vars)
+		if err != nil {
+			if saved != nil {
+				*db = *saved
+			}
+			return nil, err
+		}
+		if returned {
+			break
+		}
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	if res == nil {
+		res = []interface{}{}
+	}
+	return res, nil
+}
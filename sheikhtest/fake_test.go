@@ -0,0 +1,185 @@
+package sheikhtest
+
+import (
+	"sheikh"
+	"testing"
+)
+
+var fakeSchema = &sheikh.Schema{
+	Classes: []sheikh.Class{
+		{Name: "Gopher", SuperClasses: []string{"V"}, Properties: []sheikh.Property{
+			{Name: "name", Type: "STRING", Mandatory: true},
+			{Name: "age", Type: "INTEGER", Min: "0"},
+		}},
+		{Name: "knows", SuperClasses: []string{"E"}},
+	},
+	Indexes: []sheikh.Index{{Name: "Gopher.name", Class: "Gopher", Type: "UNIQUE", Fields: []string{"name"}}},
+}
+
+func TestFakeGraph(t *testing.T) {
+	c := NewFake(t, Schema(fakeSchema))
+	alice, bob := sheikh.NewVertex("Gopher"), sheikh.NewVertex("Gopher")
+	alice.SetProps("name", "Alice", "age", 3)
+	bob.SetProps("name", "Bob", "age", 5)
+	for _, v := range []*sheikh.Vertex{&alice, &bob} {
+		if err := c.InsertVertex(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := sheikh.CreateEdge(&alice, "knows", &bob)
+	if err := c.InsertEdge(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	vs, err := c.SelectVertexes("Gopher", 0, "WHERE age > 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].PropRequireStr("name") != "Bob" {
+		t.Errorf("SelectVertexes returned %v instead of Bob", vs)
+	}
+	es, err := alice.Edges(sheikh.Out, nil, "knows", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].Entry.Rid != e.Entry.Rid {
+		t.Errorf("Edges returned %v instead of %s", es, e.Entry.Rid)
+	}
+
+	if err = c.Increment(&alice.Entry, "age", 2); err != nil {
+		t.Fatal(err)
+	}
+	if age := alice.PropRequireInt("age"); age != 5 {
+		t.Errorf("age is %v after Increment", age)
+	}
+	bob.SetProps("age", 6)
+	if err = c.UpdateVertex(&bob); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.DeleteVertexesWhere("Gopher", "age >= ?", 6); err != nil || n != 1 {
+		t.Errorf("DeleteVertexesWhere deleted %v vertexes, error %v", n, err)
+	}
+	if es, err = c.SelectEdges("knows", 0, ""); err != nil || len(es) != 0 {
+		t.Errorf("edges of the deleted vertex remained: %v, error %v", es, err)
+	}
+}
+
+func TestFakeConstraints(t *testing.T) {
+	c := NewFake(t, Schema(fakeSchema))
+	v := sheikh.NewVertex("Gopher")
+	v.SetProps("name", "Alice")
+	if err := c.InsertVertex(&v); err != nil {
+		t.Fatal(err)
+	}
+	duplicate := sheikh.NewVertex("Gopher")
+	duplicate.SetProps("name", "Alice")
+	if err := c.InsertVertex(&duplicate); err == nil {
+		t.Errorf("unique index accepted duplicate name")
+	}
+	if _, err := c.Command("SELECT FROM Missing"); err == nil {
+		t.Errorf("SELECT from missing class succeeded")
+	}
+
+	res, err := c.Batch(
+		"LET a = CREATE VERTEX Gopher SET name = 'Bob'",
+		"LET b = CREATE VERTEX Gopher SET name = 'Carol'",
+		"RETURN $b",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Errorf("Batch returned %v instead of Carol", res)
+	}
+	if _, err = c.Batch("CREATE VERTEX Gopher SET name = 'Dave'", "CREATE VERTEX Gopher SET name = 'Alice'"); err == nil {
+		t.Errorf("Batch violating unique index succeeded")
+	}
+	if vs, _ := c.SelectVertexes("Gopher", 0, "WHERE name = 'Dave'"); len(vs) != 0 {
+		t.Errorf("failed transaction wasn't rolled back")
+	}
+
+	current, err := c.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := current.AllProperties("Gopher"); len(p) != 2 || !p[0].Mandatory || p[1].Min != "0" {
+		t.Errorf("Schema returned properties %v", p)
+	}
+	if plan, err := fakeSchema.Plan(c); err != nil || len(plan) != 0 {
+		t.Errorf("applied schema has pending changes %v, error %v", plan, err)
+	}
+}
+
+func TestFakeUpsert(t *testing.T) {
+	c := NewFake(t, Script("CREATE CLASS Gopher EXTENDS V"))
+	for _, expected := range []bool{true, false} {
+		v := sheikh.NewVertex("Gopher")
+		v.SetProps("name", "Alice", "nest", map[string]interface{}{"depth": 2})
+		created, err := c.UpsertVertex(&v, "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != expected || v.Entry.Rid == "" {
+			t.Errorf("UpsertVertex returned created %v and RID %q", created, v.Entry.Rid)
+		}
+	}
+	res, err := c.Command("SELECT count(*) FROM Gopher WHERE nest.depth = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := res[0].(map[string]interface{})["count"]; count != 1.0 {
+		t.Errorf("upserted vertex counted %v times", count)
+	}
+}
+
+func TestFakeDialect3(t *testing.T) {
+	fs := NewFakeServer("fake", Username, Password)
+	defer fs.Close()
+	fs.Version = "3.0.fake"
+	c := fs.Connection()
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if dialect := c.Transport.(*sheikh.RESTTransport).Dialect; dialect != 3 {
+		t.Fatalf("detected dialect %v instead of 3", dialect)
+	}
+	if _, err := fakeSchema.Apply(&c); err != nil {
+		t.Fatal(err)
+	}
+	v := sheikh.NewVertex("Gopher")
+	v.SetProps("name", "Alice", "age", 3)
+	if err := c.InsertVertex(&v); err != nil {
+		t.Fatal(err)
+	}
+	v.SetProps("age", 4)
+	if err := c.UpdateVertex(&v); err != nil || v.Entry.Version != 2 {
+		t.Errorf("UpdateVertex left version %v, error %v", v.Entry.Version, err)
+	}
+	if n, err := c.UpdateWhere("Gopher", map[string]interface{}{"age": 5}, "name = ?", "Alice"); err != nil || n != 1 {
+		t.Errorf("UpdateWhere updated %v vertexes, error %v", n, err)
+	}
+	res, err := c.Command("SELECT name FROM Gopher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := res[0].(map[string]interface{}); rec["name"] != "Alice" || rec["@rid"] != nil {
+		t.Errorf("projection returned as %v", rec)
+	}
+	if n, err := c.DeleteVertexesWhere("Gopher", "age = ?", 5); err != nil || n != 1 {
+		t.Errorf("DeleteVertexesWhere deleted %v vertexes, error %v", n, err)
+	}
+}
+
+func TestFakeUnknownVersion(t *testing.T) {
+	fs := NewFakeServer("fake", Username, Password)
+	defer fs.Close()
+	fs.Version = "unknown"
+	c := fs.Connection()
+	if err := c.Connect(); err == nil {
+		t.Errorf("Connect to server of unknown version succeeded")
+	}
+	c.Transport.(*sheikh.RESTTransport).Dialect = 2
+	if err := c.Connect(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,434 @@
+package sheikhtest
+
+import (
+	"bufio"
+	wire "encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sheikh"
+	"strings"
+	"testing"
+)
+
+/* This file implements OrientDB binary protocol (version 36) over the fake database, for sheikh.BinaryTransport:
+DB_OPEN, DB_CLOSE, synchronous COMMAND (SQL commands and scripts) and RECORD_LOAD/CREATE/UPDATE/DELETE, with records
+serialized in CSV format. Schema and indexes are returned for metadata:schema and metadata:indexmanager queries. */
+
+var fakeRidPattern = regexp.MustCompile(`^#(-?\d+):(\d+)$`)
+
+/* ListenBinary starts serving binary protocol on a local port, if it isn't served yet, and returns the port. The
+listener is closed along with the server. */
+func (fs *FakeServer) ListenBinary() (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.binary == nil {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		fs.binary, fs.binaryConns = l, make(map[net.Conn]bool)
+		go fs.acceptBinary(l)
+	}
+	_, port, _ := net.SplitHostPort(fs.binary.Addr().String())
+	return port, nil
+}
+
+/* BinaryConnection returns new connection to the fake server using sheikh.BinaryTransport; it still has to be
+initialized with Connect. */
+func (fs *FakeServer) BinaryConnection() (sheikh.Connection, error) {
+	c := fs.Connection()
+	port, err := fs.ListenBinary()
+	if err != nil {
+		return c, err
+	}
+	c.Transport = &sheikh.BinaryTransport{Port: port}
+	return c, nil
+}
+
+// Close shuts down the server, including binary protocol listener and its connections.
+func (fs *FakeServer) Close() {
+	fs.mu.Lock()
+	if fs.binary != nil {
+		fs.binary.Close()
+		for conn := range fs.binaryConns {
+			conn.Close()
+		}
+	}
+	fs.mu.Unlock()
+	fs.Server.Close()
+}
+
+// NewFakeBinary is like NewFake, but the returned connection uses binary protocol.
+func NewFakeBinary(t testing.TB, fixtures ...Fixture) *sheikh.Connection {
+	t.Helper()
+	fs := NewFakeServer("fake", Username, Password)
+	t.Cleanup(fs.Close)
+	c, err := fs.BinaryConnection()
+	if err == nil {
+		err = c.Connect()
+	}
+	if err != nil {
+		t.Fatalf("sheikhtest: %v", err)
+	}
+	t.Cleanup(func() { c.Transport.(*sheikh.BinaryTransport).Close() })
+	for ind, fixture := range fixtures {
+		if err := fixture(&c); err != nil {
+			t.Fatalf("sheikhtest: fixture %v failed: %v", ind, err)
+		}
+	}
+	return &c
+}
+
+func (fs *FakeServer) acceptBinary(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		fs.mu.Lock()
+		fs.binaryConns[conn] = true
+		fs.mu.Unlock()
+		go fs.serveBinary(conn)
+	}
+}
+
+// fakeFrame reads requests and writes responses of binary protocol, remembering the first read error.
+type fakeFrame struct {
+	rw  *bufio.ReadWriter
+	err error
+}
+
+func (f *fakeFrame) read(n int) []byte {
+	buff := make([]byte, n)
+	if f.err == nil {
+		_, f.err = io.ReadFull(f.rw, buff)
+	}
+	return buff
+}
+
+func (f *fakeFrame) readByte() byte {
+	return f.read(1)[0]
+}
+
+func (f *fakeFrame) readShort() int16 {
+	return int16(wire.BigEndian.Uint16(f.read(2)))
+}
+
+func (f *fakeFrame) readInt() int32 {
+	return int32(wire.BigEndian.Uint32(f.read(4)))
+}
+
+func (f *fakeFrame) readLong() int64 {
+	return int64(wire.BigEndian.Uint64(f.read(8)))
+}
+
+func (f *fakeFrame) readBytes() []byte {
+	n := f.readInt()
+	if n < 0 || f.err != nil {
+		return nil
+	}
+	if n > 1<<24 {
+		f.err = errors.New(fmt.Sprintf("bad length %v", n))
+		return nil
+	}
+	return f.read(int(n))
+}
+
+func (f *fakeFrame) readString() string {
+	return string(f.readBytes())
+}
+
+func (f *fakeFrame) write(vals ...interface{}) {
+	for _, val := range vals {
+		switch t := val.(type) {
+		case byte:
+			f.rw.WriteByte(t)
+		case bool:
+			if t {
+				f.rw.WriteByte(1)
+			} else {
+				f.rw.WriteByte(0)
+			}
+		case int16, int32, int64:
+			wire.Write(f.rw, wire.BigEndian, t)
+		case []byte:
+			if t == nil {
+				wire.Write(f.rw, wire.BigEndian, int32(-1))
+			} else {
+				wire.Write(f.rw, wire.BigEndian, int32(len(t)))
+				f.rw.Write(t)
+			}
+		case string:
+			f.write([]byte(t))
+		}
+	}
+}
+
+/* serveBinary serves one client connection: protocol version is sent first, then requests are answered until the
+client closes the database or the connection. */
+func (fs *FakeServer) serveBinary(conn net.Conn) {
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.binaryConns, conn)
+		fs.mu.Unlock()
+		conn.Close()
+	}()
+	f := &fakeFrame{rw: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))}
+	f.write(int16(36))
+	f.rw.Flush()
+	var session int32 = -1
+	for {
+		op, reqSession := f.readByte(), f.readInt()
+		if f.err != nil || op == 5 { // DB_CLOSE
+			return
+		}
+		fs.mu.Lock()
+		var err error
+		if op != 3 && (session == -1 || reqSession != session) {
+			err = errorf("OSecurityException: database isn't open in session %v", reqSession)
+			f.err = errors.New("no session")
+		} else {
+			err = fs.serveBinaryOp(f, op, &session)
+		}
+		fs.mu.Unlock()
+		if f.err != nil && err == nil {
+			return
+		}
+		if err != nil {
+			f.write(byte(1), reqSession, byte(1), "com.orientechnologies.common.exception.OException", err.Error(),
+				byte(0), []byte{})
+		}
+		f.rw.Flush()
+		if f.err != nil {
+			return
+		}
+	}
+}
+
+/* serveBinaryOp reads the request of the operation and writes the response, or returns error to be reported to the
+client. */
+func (fs *FakeServer) serveBinaryOp(f *fakeFrame, op byte, session *int32) error {
+	switch op {
+	case 3: // DB_OPEN
+		f.readString() // driver name
+		f.readString() // driver version
+		f.readShort()  // protocol version
+		f.readBytes()  // client ID
+		f.readString() // serializer
+		f.read(3)      // token session, push notifications, stats
+		database, user, pass := f.readString(), f.readString(), f.readString()
+		if f.err != nil {
+			return nil
+		}
+		if database != fs.Database || user != fs.Username || pass != fs.Password {
+			f.err = errors.New("authentication failed") // the connection is closed after the error
+			return errorf("OSecurityAccessException: wrong database, user name or password")
+		}
+		*session = int32(len(fs.sessions) + 1)
+		fs.sessions[fmt.Sprint("binary", *session)] = true
+		f.write(byte(0), int32(-1), *session, []byte(nil), int16(0), []byte(nil), fs.Version)
+	case 41: // COMMAND
+		f.readByte() // mode
+		payload := &fakeFrame{rw: bufio.NewReadWriter(bufio.NewReader(strings.NewReader(string(f.readBytes()))), nil)}
+		if f.err != nil {
+			return nil
+		}
+		res, err := fs.binaryCommand(payload)
+		if err != nil {
+			return err
+		}
+		f.write(byte(0), *session, byte('l'), int32(len(res)))
+		for _, rec := range res {
+			writeFakeRecord(f, rec)
+		}
+		f.write(byte(0))
+	case 30: // RECORD_LOAD
+		rid := fmt.Sprintf("#%v:%v", f.readShort(), f.readLong())
+		f.readString() // fetch plan
+		f.read(2)      // ignore cache, load tombstones
+		if f.err != nil {
+			return nil
+		}
+		f.write(byte(0), *session)
+		if rec := fs.db.records[rid]; rec != nil {
+			f.write(byte(1), byte('d'), int32(rec.version), fakeCSV(rec.class, rec.fields))
+		}
+		f.write(byte(0))
+	case 31: // RECORD_CREATE
+		f.readShort() // cluster
+		content := f.readBytes()
+		f.read(2) // record type, mode
+		if f.err != nil {
+			return nil
+		}
+		class, fields, err := sheikh.UnmarshalRecordCSV(content)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		rec, err := fs.db.insert(class, stripMeta(fields))
+		if err != nil {
+			return err
+		}
+		f.write(byte(0), *session, int16(rec.cluster), int64(rec.position), int32(rec.version))
+		fs.writeCollectionChanges(f)
+	case 32: // RECORD_UPDATE
+		rid := fmt.Sprintf("#%v:%v", f.readShort(), f.readLong())
+		f.readByte() // update content
+		content := f.readBytes()
+		version := f.readInt()
+		f.read(2) // record type, mode
+		if f.err != nil {
+			return nil
+		}
+		rec := fs.db.records[rid]
+		if rec == nil {
+			return errorf("ORecordNotFoundException: record %s not found", rid)
+		}
+		if version >= 0 && int(version) != rec.version {
+			return errorf("OConcurrentModificationException: record %s has version %v, your version is %v", rid,
+				rec.version, version)
+		}
+		_, fields, err := sheikh.UnmarshalRecordCSV(content)
+		if err != nil {
+			return errorf("%v", err)
+		}
+		updated := rec.copy()
+		updated.fields = stripMeta(fields)
+		for name, val := range rec.fields { // graph fields are kept
+			if isGraphField(name) {
+				updated.fields[name] = val
+			}
+		}
+		if err := fs.db.save(updated); err != nil {
+			return err
+		}
+		f.write(byte(0), *session, int32(updated.version))
+		fs.writeCollectionChanges(f)
+	case 33: // RECORD_DELETE
+		rid := fmt.Sprintf("#%v:%v", f.readShort(), f.readLong())
+		version := f.readInt()
+		f.readByte() // mode
+		if f.err != nil {
+			return nil
+		}
+		rec := fs.db.records[rid]
+		if rec != nil && version >= 0 && int(version) != rec.version {
+			return errorf("OConcurrentModificationException: record %s has version %v, your version is %v", rid,
+				rec.version, version)
+		}
+		fs.db.remove(rid)
+		f.write(byte(0), *session, rec != nil)
+	default:
+		f.err = errors.New(fmt.Sprintf("unsupported operation %v", op)) // the request can't be skipped without knowing its format
+		return errorf("operation %v is not supported by the fake server", op)
+	}
+	return nil
+}
+
+/* writeCollectionChanges writes changes of tree-based RidBags: UUID (two longs), file ID, page index and page
+offset of each. */
+func (fs *FakeServer) writeCollectionChanges(f *fakeFrame) {
+	f.write(int32(fs.binaryChanges))
+	for ind := 0; ind < fs.binaryChanges; ind++ {
+		f.write(int64(ind), int64(ind), int64(1), int64(2), int32(3))
+	}
+}
+
+/* binaryCommand runs command from COMMAND request payload. Scripts are split into statements; BEGIN and COMMIT
+statements make them run in transaction. */
+func (fs *FakeServer) binaryCommand(payload *fakeFrame) ([]interface{}, error) {
+	class := payload.readString()
+	if class == "s" && !strings.EqualFold(payload.readString(), "sql") {
+		return nil, errorf("only SQL scripts are supported")
+	}
+	text := payload.readString()
+	if payload.err != nil || class != "c" && class != "s" {
+		return nil, errorf("unsupported command class %q", class)
+	}
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "select expand(classes) from metadata:schema":
+		return generic(fs.db.schemaJson()["classes"]).([]interface{}), nil
+	case "select expand(indexes) from metadata:indexmanager":
+		return fs.db.indexesJson(), nil
+	}
+	var script []string
+	transaction := false
+	for _, stmt := range strings.Split(text, ";\n") {
+		switch strings.ToUpper(strings.TrimSpace(stmt)) {
+		case "BEGIN":
+			transaction = true
+		case "COMMIT":
+		default:
+			script = append(script, stmt)
+		}
+	}
+	return fs.db.run(script, transaction, -1)
+}
+
+// indexesJson renders indexes as metadata:indexmanager query does.
+func (db *fakeDB) indexesJson() []interface{} {
+	ret := []interface{}{}
+	for _, idx := range db.indexes {
+		def := map[string]interface{}{"className": idx.Class}
+		if len(idx.Fields) == 1 {
+			def["field"] = idx.Fields[0]
+		} else {
+			var defs []interface{}
+			for _, field := range idx.Fields {
+				defs = append(defs, map[string]interface{}{"@type": "d", "className": idx.Class, "field": field})
+			}
+			def["indexDefinitions"] = defs
+		}
+		def["@type"] = "d"
+		ret = append(ret, map[string]interface{}{"name": idx.Name, "type": idx.Type, "indexDefinition": def})
+	}
+	return ret
+}
+
+// generic converts the value to JSON-like types, as they are decoded from JSON.
+func generic(val interface{}) interface{} {
+	buff, _ := json.Marshal(val)
+	dec := json.NewDecoder(strings.NewReader(string(buff)))
+	dec.UseNumber()
+	var ret interface{}
+	dec.Decode(&ret)
+	return normalize(ret)
+}
+
+func fakeCSV(class string, fields map[string]interface{}) []byte {
+	return sheikh.MarshalRecordCSV(class, generic(stripMeta(fields)).(map[string]interface{}))
+}
+
+/* writeFakeRecord writes command result as a record: maps with @rid as stored records, other maps as projections
+(with negative cluster ID), RIDs as links and other values as projections with the value field. */
+func writeFakeRecord(f *fakeFrame, val interface{}) {
+	if str, ok := val.(string); ok && fakeRidPattern.MatchString(str) {
+		var cluster int16
+		var position int64
+		fmt.Sscanf(str, "#%d:%d", &cluster, &position)
+		f.write(int16(-3), cluster, position)
+		return
+	}
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{"value": val}
+	}
+	var cluster int16 = -2
+	var position int64
+	if rid, ok := m["@rid"].(string); ok && fakeRidPattern.MatchString(rid) {
+		fmt.Sscanf(rid, "#%d:%d", &cluster, &position)
+	}
+	var version int32
+	switch t := m["@version"].(type) {
+	case int:
+		version = int32(t)
+	case float64:
+		version = int32(t)
+	}
+	class, _ := m["@class"].(string)
+	f.write(int16(0), byte('d'), cluster, position, version, fakeCSV(class, m))
+}
@@ -0,0 +1,146 @@
+package sheikhtest
+
+import (
+	"sheikh"
+	"testing"
+)
+
+func TestFakeBinaryGraph(t *testing.T) {
+	c := NewFakeBinary(t, Schema(fakeSchema))
+	alice, bob := sheikh.NewVertex("Gopher"), sheikh.NewVertex("Gopher")
+	alice.SetProps("name", "Alice", "age", 3, "tags", []interface{}{"x", "y"})
+	bob.SetProps("name", "Bob \"the\" gopher", "age", 5)
+	for _, v := range []*sheikh.Vertex{&alice, &bob} {
+		if err := c.InsertVertex(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := sheikh.CreateEdge(&alice, "knows", &bob)
+	if err := c.InsertEdge(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	vs, err := c.SelectVertexes("Gopher", 0, "WHERE age > 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 || vs[0].PropRequireStr("name") != "Bob \"the\" gopher" {
+		t.Errorf("SelectVertexes returned %v instead of Bob", vs)
+	}
+	es, err := alice.Edges(sheikh.Out, nil, "knows", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || es[0].Entry.Rid != e.Entry.Rid {
+		t.Errorf("Edges returned %v instead of %s", es, e.Entry.Rid)
+	}
+	if err = c.Increment(&alice.Entry, "age", 2); err != nil {
+		t.Fatal(err)
+	}
+	if age := alice.PropRequireInt("age"); age != 5 {
+		t.Errorf("age is %v after Increment", age)
+	}
+	if _, err = c.Batch("CREATE VERTEX Gopher SET name = 'Dave'", "CREATE VERTEX Gopher SET name = 'Alice'"); err == nil {
+		t.Errorf("Batch violating unique index succeeded")
+	}
+	if vs, _ := c.SelectVertexes("Gopher", 0, "WHERE name = 'Dave'"); len(vs) != 0 {
+		t.Errorf("failed transaction wasn't rolled back")
+	}
+
+	current, err := c.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := fakeSchema.Plan(c); err != nil || len(plan) != 0 {
+		t.Errorf("applied schema has pending changes %v, error %v", plan, err)
+	}
+	if p := current.AllProperties("Gopher"); len(p) != 2 || !p[0].Mandatory || p[1].Min != "0" {
+		t.Errorf("Schema returned properties %v", p)
+	}
+}
+
+func TestFakeBinaryRecords(t *testing.T) {
+	c := NewFakeBinary(t, Schema(fakeSchema))
+	bt := c.Transport.(*sheikh.BinaryTransport)
+	rid, version, err := bt.CreateRecord(c, "Gopher", map[string]interface{}{"name": "Alice", "age": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := bt.LoadRecord(c, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec["name"] != "Alice" || rec["age"] != 3.0 || rec["@class"] != "Gopher" || rec["@rid"] != rid {
+		t.Errorf("LoadRecord returned %v", rec)
+	}
+
+	if _, err = bt.UpdateRecord(c, rid, version+1, "Gopher", map[string]interface{}{"name": "Bob"}); err == nil {
+		t.Errorf("UpdateRecord with wrong version succeeded")
+	}
+	if version, err = bt.UpdateRecord(c, rid, version, "Gopher", map[string]interface{}{"name": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ = bt.LoadRecord(c, rid); rec["name"] != "Bob" || rec["@version"] != float64(version) {
+		t.Errorf("LoadRecord returned %v after update", rec)
+	}
+
+	if deleted, err := bt.DeleteRecord(c, rid, version); err != nil || !deleted {
+		t.Errorf("DeleteRecord returned %v, error %v", deleted, err)
+	}
+	if rec, err = bt.LoadRecord(c, rid); err != nil || rec != nil {
+		t.Errorf("LoadRecord of deleted record returned %v, error %v", rec, err)
+	}
+	if deleted, err := bt.DeleteRecord(c, rid, -1); err != nil || deleted {
+		t.Errorf("DeleteRecord of deleted record returned %v, error %v", deleted, err)
+	}
+}
+
+func TestFakeBinaryAuth(t *testing.T) {
+	fs := NewFakeServer("fake", Username, Password)
+	defer fs.Close()
+	c, err := fs.BinaryConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Password = "wrong"
+	if err = c.Connect(); err == nil {
+		t.Errorf("Connect with wrong password succeeded")
+	}
+	c.Password = Password
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	c.Transport.(*sheikh.BinaryTransport).Close()
+	if _, err = c.Command("SELECT FROM V"); err != nil { // reconnects
+		t.Error(err)
+	}
+}
+
+func TestFakeBinaryCollectionChanges(t *testing.T) {
+	fs := NewFakeServer("fake", Username, Password)
+	defer fs.Close()
+	fs.binaryChanges = 2
+	c, err := fs.BinaryConnection()
+	if err == nil {
+		err = c.Connect()
+	}
+	if err == nil {
+		err = Script("CREATE CLASS Gopher EXTENDS V")(&c)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := c.Transport.(*sheikh.BinaryTransport)
+	defer bt.Close()
+	rid, version, err := bt.CreateRecord(&c, "Gopher", map[string]interface{}{"name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, err = bt.UpdateRecord(&c, rid, version, "Gopher", map[string]interface{}{"name": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	// the stream stays in sync only if changes were read whole
+	if rec, err := bt.LoadRecord(&c, rid); err != nil || rec["name"] != "Bob" || rec["@version"] != float64(version) {
+		t.Errorf("LoadRecord after changes returned %v, error %v", rec, err)
+	}
+}
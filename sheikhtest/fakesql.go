@@ -0,0 +1,2033 @@
+package sheikhtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sheikh"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+// This file contains interpreter of the SQL subset understood by FakeServer.
+
+const (
+	tkEOF = iota
+	tkIdent
+	tkString
+	tkNumber
+	tkRid
+	tkPunct
+)
+
+type token struct {
+	kind       int
+	text       string
+	val        interface{} // decoded strings and numbers
+	start, end int         // offsets in the statement
+}
+
+var ridToken = regexp.MustCompile(`^#-?\d+:-?\d+`)
+
+// lex splits SQL statement into tokens.
+func lex(src string) ([]token, error) {
+	var ret []token
+	pos := 0
+	for pos < len(src) {
+		r := rune(src[pos])
+		start := pos
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+			continue
+		case r == '#':
+			m := ridToken.FindString(src[pos:])
+			if m == "" {
+				return nil, errorf("Error parsing query: unexpected # at %v in %q", pos, src)
+			}
+			pos += len(m)
+			ret = append(ret, token{kind: tkRid, text: m, val: m})
+		case unicode.IsLetter(r) || r == '_' || r == '@' || r == '$':
+			for pos < len(src) && (unicode.IsLetter(rune(src[pos])) || unicode.IsDigit(rune(src[pos])) ||
+				strings.ContainsRune("_@$", rune(src[pos])) || src[pos] >= 0x80) {
+				pos++
+			}
+			ret = append(ret, token{kind: tkIdent, text: src[start:pos]})
+		case r == '`':
+			end := strings.IndexByte(src[pos+1:], '`')
+			if end == -1 {
+				return nil, errorf("Error parsing query: unclosed ` in %q", src)
+			}
+			pos += end + 2
+			ret = append(ret, token{kind: tkIdent, text: src[start+1 : pos-1]})
+		case unicode.IsDigit(r):
+			for pos < len(src) && (unicode.IsDigit(rune(src[pos])) || src[pos] == '.' ||
+				((src[pos] == 'e' || src[pos] == 'E') && pos+1 < len(src)) ||
+				((src[pos] == '-' || src[pos] == '+') && (src[pos-1] == 'e' || src[pos-1] == 'E'))) {
+				pos++
+			}
+			num, err := parseNumber(src[start:pos])
+			if err != nil {
+				return nil, errorf("Error parsing query: bad number %s", src[start:pos])
+			}
+			ret = append(ret, token{kind: tkNumber, text: src[start:pos], val: num})
+		case r == '\'' || r == '"':
+			str, length, err := unquote(src[pos:])
+			if err != nil {
+				return nil, err
+			}
+			pos += length
+			ret = append(ret, token{kind: tkString, text: src[start:pos], val: str})
+		default:
+			if pos+1 < len(src) && strings.Contains("<= >= <> != ||", src[pos:pos+2]) && src[pos+1] != ' ' {
+				pos += 2
+			} else if strings.ContainsRune("()[]{},=<>.:*;+-/", r) {
+				pos++
+			} else {
+				return nil, errorf("Error parsing query: unexpected %q at %v in %q", r, pos, src)
+			}
+			ret = append(ret, token{kind: tkPunct, text: src[start:pos]})
+		}
+		ret[len(ret)-1].start, ret[len(ret)-1].end = start, pos
+	}
+	return append(ret, token{kind: tkEOF, start: len(src), end: len(src)}), nil
+}
+
+// unquote decodes string literal at the beginning of src, returning it and its length in src.
+func unquote(src string) (string, int, error) {
+	quote := src[0]
+	var ret []rune
+	for pos := 1; pos < len(src); pos++ {
+		switch src[pos] {
+		case quote:
+			return string(ret), pos + 1, nil
+		case '\\':
+			pos++
+			if pos == len(src) {
+				break
+			}
+			switch src[pos] {
+			case 'n':
+				ret = append(ret, '\n')
+			case 't':
+				ret = append(ret, '\t')
+			case 'r':
+				ret = append(ret, '\r')
+			case 'b':
+				ret = append(ret, '\b')
+			case 'f':
+				ret = append(ret, '\f')
+			case 'u':
+				if pos+4 >= len(src) {
+					return "", 0, errorf("Error parsing query: bad \\u escape")
+				}
+				code, err := strconv.ParseUint(src[pos+1:pos+5], 16, 16)
+				if err != nil {
+					return "", 0, errorf("Error parsing query: bad \\u escape")
+				}
+				pos += 4
+				r := rune(code)
+				if utf16.IsSurrogate(r) && pos+6 < len(src) && src[pos+1:pos+3] == "\\u" {
+					if low, err := strconv.ParseUint(src[pos+3:pos+7], 16, 16); err == nil {
+						r = utf16.DecodeRune(r, rune(low))
+						pos += 6
+					}
+				}
+				ret = append(ret, r)
+			default:
+				ret = append(ret, rune(src[pos]))
+			}
+		default:
+			r := []rune(src[pos:])[0]
+			ret = append(ret, r)
+			pos += len(string(r)) - 1
+		}
+	}
+	return "", 0, errorf("Error parsing query: unclosed string in %q", src)
+}
+
+/* parseNumber returns float64 for numbers which it represents exactly (as the driver decodes them), and int64 or
+uint64 for larger integers. */
+func parseNumber(text string) (interface{}, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if i >= -(1<<53) && i <= 1<<53 {
+			return float64(i), nil
+		}
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(text, 10, 64); err == nil {
+		return u, nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// normalize replaces json.Number values with numbers as returned by parseNumber.
+func normalize(val interface{}) interface{} {
+	switch t := val.(type) {
+	case json.Number:
+		num, err := parseNumber(t.String())
+		if err != nil {
+			return t.String()
+		}
+		return num
+	case map[string]interface{}:
+		for key, elem := range t {
+			t[key] = normalize(elem)
+		}
+	case []interface{}:
+		for ind, elem := range t {
+			t[ind] = normalize(elem)
+		}
+	}
+	return val
+}
+
+func deepCopy(val interface{}) interface{} {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for key, elem := range t {
+			ret[key] = deepCopy(elem)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for ind, elem := range t {
+			ret[ind] = deepCopy(elem)
+		}
+		return ret
+	}
+	return val
+}
+
+func repr(val interface{}) string {
+	buff, _ := json.Marshal(val)
+	return string(buff)
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch t := val.(type) {
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	}
+	return 0, false
+}
+
+// equal compares values as the database does: numbers by value, strings converted to numbers when compared with them.
+func equal(a, b interface{}) bool {
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			return ai == bi
+		}
+	}
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	if aNum && bNum {
+		return af == bf
+	}
+	if str, ok := a.(string); ok && bNum {
+		f, err := strconv.ParseFloat(str, 64)
+		return err == nil && f == bf
+	}
+	if str, ok := b.(string); ok && aNum {
+		f, err := strconv.ParseFloat(str, 64)
+		return err == nil && f == af
+	}
+	if rec, ok := a.(map[string]interface{}); ok && rec["@rid"] != nil {
+		return equal(rec["@rid"], b)
+	}
+	if rec, ok := b.(map[string]interface{}); ok && rec["@rid"] != nil {
+		return equal(a, rec["@rid"])
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare returns -1, 0 or 1, or false if the values can't be ordered; nulls go first.
+func compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0, true
+		case a == nil:
+			return -1, true
+		}
+		return 1, true
+	}
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	as, aStr := a.(string)
+	bs, bStr := b.(string)
+	switch {
+	case aNum && bNum:
+	case aStr && bStr:
+		return strings.Compare(as, bs), true
+	case aStr && bNum:
+		var err error
+		if af, err = strconv.ParseFloat(as, 64); err != nil {
+			return 0, false
+		}
+	case aNum && bStr:
+		var err error
+		if bf, err = strconv.ParseFloat(bs, 64); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+func truthy(val interface{}) bool {
+	b, ok := val.(bool)
+	return ok && b
+}
+
+// evalCtx is the record an expression is evaluated for, along with variables of the script.
+type evalCtx struct {
+	db   *fakeDB
+	rec  *fakeRecord
+	vars map[string][]interface{}
+}
+
+type expr func(ctx *evalCtx) (interface{}, error)
+
+func constExpr(val interface{}) expr {
+	return func(*evalCtx) (interface{}, error) {
+		return deepCopy(val), nil
+	}
+}
+
+// lookup returns value of the field path (e.g. address.city or @rid) in the record.
+func lookup(rec *fakeRecord, path string) interface{} {
+	if rec == nil {
+		return nil
+	}
+	switch strings.ToLower(path) {
+	case "@rid":
+		return rec.rid()
+	case "@class":
+		return rec.class
+	case "@version":
+		return float64(rec.version)
+	}
+	var cur interface{} = rec.fields
+	for _, name := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[name]
+	}
+	return cur
+}
+
+// parser executes SQL statements, parsing expressions into closures evaluated for each record.
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+	db   *fakeDB
+	vars map[string][]interface{}
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tkEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isKw(words ...string) bool {
+	for ind, word := range words {
+		if p.pos+ind >= len(p.toks) {
+			return false
+		}
+		tok := p.toks[p.pos+ind]
+		if tok.kind != tkIdent || !strings.EqualFold(tok.text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) acceptKw(words ...string) bool {
+	if p.isKw(words...) {
+		p.pos += len(words)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKw(word string) error {
+	if !p.acceptKw(word) {
+		return p.unexpected(word)
+	}
+	return nil
+}
+
+func (p *parser) isPunct(text string) bool {
+	return p.peek().kind == tkPunct && p.peek().text == text
+}
+
+func (p *parser) acceptPunct(text string) bool {
+	if p.isPunct(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(text string) error {
+	if !p.acceptPunct(text) {
+		return p.unexpected(text)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	tok := p.peek()
+	found := tok.text
+	if tok.kind == tkEOF {
+		found = "end of statement"
+	}
+	return errorf("Error parsing query: expected %s, found %s at position %v in %q", expected, found, tok.start, p.src)
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.next()
+	if tok.kind != tkIdent {
+		p.pos--
+		return "", p.unexpected("name")
+	}
+	return tok.text, nil
+}
+
+// path parses dotted field path, e.g. address.city.
+func (p *parser) path() (string, error) {
+	name, err := p.ident()
+	if err != nil {
+		return "", err
+	}
+	for p.isPunct(".") && p.toks[p.pos+1].kind == tkIdent {
+		p.pos++
+		name += "." + p.next().text
+	}
+	return name, nil
+}
+
+// rest returns source text of remaining tokens, consuming them.
+func (p *parser) rest() string {
+	start := p.peek().start
+	p.pos = len(p.toks) - 1
+	return strings.TrimSpace(p.src[start:])
+}
+
+var clauseKeywords = map[string]bool{
+	"WHERE": true, "LIMIT": true, "ORDER": true, "SKIP": true, "SET": true, "REMOVE": true, "INCREMENT": true,
+	"ADD": true, "PUT": true, "MERGE": true, "CONTENT": true, "UPSERT": true, "RETURN": true, "FROM": true, "TO": true,
+	"AS": true, "AND": true, "OR": true, "NOT": true, "IN": true, "IS": true, "LIKE": true, "CONTAINS": true,
+	"OFFSET": true, "GROUP": true, "ASC": true, "DESC": true, "UNSAFE": true, "TIMEOUT": true,
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKw("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ctx *evalCtx) (interface{}, error) {
+			lv, err := l(ctx)
+			if err != nil || truthy(lv) {
+				return lv, err
+			}
+			return right(ctx)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKw("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ctx *evalCtx) (interface{}, error) {
+			lv, err := l(ctx)
+			if err != nil || !truthy(lv) {
+				return false, err
+			}
+			rv, err := right(ctx)
+			return truthy(rv), err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.acceptKw("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *evalCtx) (interface{}, error) {
+			val, err := operand(ctx)
+			return !truthy(val), err
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func binary(left, right expr, fn func(a, b interface{}) bool) expr {
+	return func(ctx *evalCtx) (interface{}, error) {
+		a, err := left(ctx)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return fn(a, b), nil
+	}
+}
+
+// members returns elements of a collection, or the value itself if it's not a collection.
+func members(val interface{}) []interface{} {
+	switch t := val.(type) {
+	case []interface{}:
+		return t
+	case nil:
+		return nil
+	}
+	return []interface{}{val}
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKw("IS") {
+		negate := p.acceptKw("NOT")
+		if err := p.expectKw("NULL"); err != nil {
+			return nil, err
+		}
+		return func(ctx *evalCtx) (interface{}, error) {
+			val, err := left(ctx)
+			return (val == nil) != negate, err
+		}, nil
+	}
+	negate := p.isKw("NOT", "IN") || p.isKw("NOT", "LIKE")
+	if negate {
+		p.pos++
+	}
+	var ret expr
+	switch {
+	case p.acceptKw("IN"):
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		ret = binary(left, right, func(a, b interface{}) bool {
+			for _, member := range members(b) {
+				if rec, ok := member.(map[string]interface{}); ok && rec["@rid"] == nil && len(rec) == 1 {
+					for _, val := range rec { // projection of a subquery
+						member = val
+					}
+				}
+				if equal(a, member) {
+					return true
+				}
+			}
+			return false
+		})
+	case p.acceptKw("LIKE"):
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		ret = binary(left, right, func(a, b interface{}) bool {
+			str, ok := a.(string)
+			pattern, patOk := b.(string)
+			if !ok || !patOk {
+				return false
+			}
+			re := "^" + strings.NewReplacer("%", ".*", "_", ".").Replace(regexp.QuoteMeta(pattern)) + "$"
+			matched, _ := regexp.MatchString("(?is)"+re, str)
+			return matched
+		})
+	case p.acceptKw("CONTAINS"):
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		ret = binary(left, right, func(a, b interface{}) bool {
+			for _, member := range members(a) {
+				if equal(member, b) {
+					return true
+				}
+			}
+			return false
+		})
+	case p.peek().kind == tkPunct:
+		op := p.peek().text
+		var fn func(a, b interface{}) bool
+		switch op {
+		case "=":
+			fn = equal
+		case "!=", "<>":
+			fn = func(a, b interface{}) bool { return !equal(a, b) }
+		case "<", "<=", ">", ">=":
+			fn = func(a, b interface{}) bool {
+				cmp, ok := compare(a, b)
+				if !ok || a == nil || b == nil {
+					return false
+				}
+				return map[string]bool{"<": cmp < 0, "<=": cmp <= 0, ">": cmp > 0, ">=": cmp >= 0}[op]
+			}
+		default:
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		ret = binary(left, right, fn)
+	default:
+		if negate {
+			return nil, p.unexpected("IN or LIKE")
+		}
+		return left, nil
+	}
+	if negate {
+		positive := ret
+		ret = func(ctx *evalCtx) (interface{}, error) {
+			val, err := positive(ctx)
+			return !truthy(val), err
+		}
+	}
+	return ret, nil
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tkString, tkNumber, tkRid:
+		p.pos++
+		return constExpr(tok.val), nil
+	case tkPunct:
+		switch tok.text {
+		case "-":
+			p.pos++
+			num := p.next()
+			f, ok := toFloat(num.val)
+			if num.kind != tkNumber || !ok {
+				return nil, p.unexpected("number")
+			}
+			if i, ok := num.val.(int64); ok {
+				return constExpr(-i), nil
+			}
+			return constExpr(-f), nil
+		case "[":
+			p.pos++
+			var elems []expr
+			for !p.acceptPunct("]") {
+				if len(elems) != 0 {
+					if err := p.expectPunct(","); err != nil {
+						return nil, err
+					}
+				}
+				elem, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, elem)
+			}
+			return func(ctx *evalCtx) (interface{}, error) {
+				ret := []interface{}{}
+				for _, elem := range elems {
+					val, err := elem(ctx)
+					if err != nil {
+						return nil, err
+					}
+					ret = append(ret, val)
+				}
+				return ret, nil
+			}, nil
+		case "{":
+			p.pos++
+			keys, vals := []string{}, []expr{}
+			for !p.acceptPunct("}") {
+				if len(keys) != 0 {
+					if err := p.expectPunct(","); err != nil {
+						return nil, err
+					}
+				}
+				key := p.next()
+				if key.kind != tkString && key.kind != tkIdent {
+					p.pos--
+					return nil, p.unexpected("map key")
+				}
+				if err := p.expectPunct(":"); err != nil {
+					return nil, err
+				}
+				val, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				keyText := key.text
+				if key.kind == tkString {
+					keyText = key.val.(string)
+				}
+				keys, vals = append(keys, keyText), append(vals, val)
+			}
+			return func(ctx *evalCtx) (interface{}, error) {
+				ret := make(map[string]interface{})
+				for ind, key := range keys {
+					val, err := vals[ind](ctx)
+					if err != nil {
+						return nil, err
+					}
+					ret[key] = val
+				}
+				return ret, nil
+			}, nil
+		case "(":
+			p.pos++
+			if p.isKw("SELECT") {
+				sel, err := p.parseSelect()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+				return func(ctx *evalCtx) (interface{}, error) {
+					res, err := sel(ctx.vars)
+					return res, err
+				}, nil
+			}
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expectPunct(")")
+		}
+	case tkIdent:
+		switch strings.ToUpper(tok.text) {
+		case "TRUE", "FALSE":
+			p.pos++
+			return constExpr(strings.EqualFold(tok.text, "true")), nil
+		case "NULL":
+			p.pos++
+			return constExpr(nil), nil
+		}
+		if strings.HasPrefix(tok.text, "$") {
+			p.pos++
+			name := tok.text[1:]
+			return func(ctx *evalCtx) (interface{}, error) {
+				val, present := ctx.vars[name]
+				if !present {
+					return nil, errorf("Variable $%s is not defined", name)
+				}
+				return val, nil
+			}, nil
+		}
+		if p.toks[p.pos+1].kind == tkPunct && p.toks[p.pos+1].text == "(" {
+			p.pos += 2
+			switch strings.ToLower(tok.text) {
+			case "sysdate", "date":
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+				return func(*evalCtx) (interface{}, error) {
+					return time.Now().Format("2006-01-02 15:04:05"), nil
+				}, nil
+			case "unionall":
+				var args []expr
+				for !p.acceptPunct(")") {
+					if len(args) != 0 {
+						if err := p.expectPunct(","); err != nil {
+							return nil, err
+						}
+					}
+					arg, err := p.parseOr()
+					if err != nil {
+						return nil, err
+					}
+					args = append(args, arg)
+				}
+				return func(ctx *evalCtx) (interface{}, error) {
+					ret := []interface{}{}
+					for _, arg := range args {
+						val, err := arg(ctx)
+						if err != nil {
+							return nil, err
+						}
+						ret = append(ret, members(val)...)
+					}
+					return ret, nil
+				}, nil
+			case "bothe":
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+				return func(ctx *evalCtx) (interface{}, error) {
+					return bothEdges(ctx.rec), nil
+				}, nil
+			}
+			return nil, errorf("Unknown function %s() in %q", tok.text, p.src)
+		}
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx *evalCtx) (interface{}, error) {
+			return deepCopy(lookup(ctx.rec, path)), nil
+		}, nil
+	}
+	return nil, p.unexpected("value")
+}
+
+// bothEdges returns RIDs of edges coming in or out of the vertex record, for bothE() function.
+func bothEdges(rec *fakeRecord) []interface{} {
+	ret := []interface{}{}
+	if rec == nil {
+		return ret
+	}
+	var names []string
+	for name := range rec.fields {
+		if strings.HasPrefix(name, "out_") || strings.HasPrefix(name, "in_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rels, _ := rec.fields[name].([]interface{})
+		ret = append(ret, rels...)
+	}
+	return ret
+}
+
+// parseValue parses expression which doesn't depend on records, such as CONTENT of a new vertex, and evaluates it.
+func (p *parser) parseValue() (interface{}, error) {
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return e(&evalCtx{db: p.db, vars: p.vars})
+}
+
+// target parses FROM target: class, RID, list of RIDs, variable or subquery; it returns function listing records.
+func (p *parser) target() (func(vars map[string][]interface{}) ([]*fakeRecord, error), error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == tkRid:
+		var rids []string
+		for {
+			rids = append(rids, p.next().text)
+			if !(p.isPunct(",") && p.toks[p.pos+1].kind == tkRid) { // DELETE EDGE #1:2,#1:3
+				break
+			}
+			p.pos++
+		}
+		return func(map[string][]interface{}) ([]*fakeRecord, error) {
+			var ret []*fakeRecord
+			for _, rid := range rids {
+				if rec := p.db.records[rid]; rec != nil {
+					ret = append(ret, rec)
+				}
+			}
+			return ret, nil
+		}, nil
+	case tok.kind == tkPunct && (tok.text == "[" || tok.text == "("), tok.kind == tkIdent && strings.HasPrefix(tok.text, "$"):
+		val, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string][]interface{}) ([]*fakeRecord, error) {
+			res, err := val(&evalCtx{db: p.db, vars: vars})
+			if err != nil {
+				return nil, err
+			}
+			var ret []*fakeRecord
+			for _, member := range members(res) {
+				if rec, ok := member.(map[string]interface{}); ok {
+					member = rec["@rid"]
+					if member == nil && len(rec) == 1 {
+						for _, val := range rec {
+							member = val
+						}
+					}
+				}
+				if found := p.db.records[fmt.Sprint(member)]; found != nil {
+					ret = append(ret, found)
+				}
+			}
+			return ret, nil
+		}, nil
+	case tok.kind == tkIdent:
+		p.pos++
+		class := tok.text
+		if p.db.class(class) == nil {
+			return nil, errorf("Class '%s' was not found in current database", class)
+		}
+		return func(map[string][]interface{}) ([]*fakeRecord, error) {
+			return p.db.scan(class), nil
+		}, nil
+	}
+	return nil, p.unexpected("target")
+}
+
+// where parses optional WHERE clause, returning nil if there is none.
+func (p *parser) where() (expr, error) {
+	if !p.acceptKw("WHERE") {
+		return nil, nil
+	}
+	return p.parseOr()
+}
+
+func (p *parser) filter(records []*fakeRecord, cond expr, vars map[string][]interface{}) ([]*fakeRecord, error) {
+	if cond == nil {
+		return records, nil
+	}
+	var ret []*fakeRecord
+	for _, rec := range records {
+		val, err := cond(&evalCtx{db: p.db, rec: rec, vars: vars})
+		if err != nil {
+			return nil, err
+		}
+		if truthy(val) {
+			ret = append(ret, rec)
+		}
+	}
+	return ret, nil
+}
+
+type projection struct {
+	name  string
+	val   expr
+	count bool
+}
+
+/* parseSelect parses SELECT statement, returning function which runs it. It's a function, as subqueries are run
+for every record. */
+func (p *parser) parseSelect() (func(vars map[string][]interface{}) ([]interface{}, error), error) {
+	if err := p.expectKw("SELECT"); err != nil {
+		return nil, err
+	}
+	var projs []projection
+	for !p.isKw("FROM") {
+		if len(projs) != 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.acceptPunct("*") {
+			continue
+		}
+		proj := projection{name: fmt.Sprintf("expr%v", len(projs))}
+		if p.isKw("count") && p.toks[p.pos+1].text == "(" {
+			p.pos += 2
+			p.acceptPunct("*")
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			proj.name, proj.count = "count", true
+		} else {
+			if p.peek().kind == tkIdent && !strings.HasPrefix(p.peek().text, "$") {
+				proj.name = strings.TrimPrefix(p.peek().text, "@")
+			}
+			val, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			proj.val = val
+		}
+		if p.acceptKw("AS") {
+			alias, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			proj.name = alias
+		}
+		projs = append(projs, proj)
+	}
+	p.pos++ // FROM
+	from, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.where()
+	if err != nil {
+		return nil, err
+	}
+	var orderBy []string
+	var desc []bool
+	if p.acceptKw("ORDER", "BY") {
+		for {
+			field, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			orderBy = append(orderBy, field)
+			desc = append(desc, p.acceptKw("DESC"))
+			p.acceptKw("ASC")
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	skip, limit := 0, -1
+	for {
+		if p.acceptKw("SKIP") || p.acceptKw("OFFSET") {
+			val, err := p.parseValue()
+			f, ok := toFloat(val)
+			if err != nil || !ok {
+				return nil, p.unexpected("number")
+			}
+			skip = int(f)
+		} else if p.acceptKw("LIMIT") {
+			val, err := p.parseValue()
+			f, ok := toFloat(val)
+			if err != nil || !ok {
+				return nil, p.unexpected("number")
+			}
+			limit = int(f)
+		} else {
+			break
+		}
+	}
+
+	return func(vars map[string][]interface{}) ([]interface{}, error) {
+		records, err := from(vars)
+		if err != nil {
+			return nil, err
+		}
+		if records, err = p.filter(records, cond, vars); err != nil {
+			return nil, err
+		}
+		if len(orderBy) != 0 {
+			sortRecords(records, orderBy, desc)
+		}
+		if skip > len(records) {
+			skip = len(records)
+		}
+		records = records[skip:]
+		if limit >= 0 && len(records) > limit {
+			records = records[:limit]
+		}
+		ret := []interface{}{}
+		if len(projs) == 1 && projs[0].count {
+			return append(ret, map[string]interface{}{"@type": "d", "@version": 0, "count": float64(len(records))}), nil
+		}
+		for _, rec := range records {
+			if len(projs) == 0 {
+				ret = append(ret, rec.toJson())
+				continue
+			}
+			row := map[string]interface{}{"@type": "d", "@version": 0}
+			for _, proj := range projs {
+				if proj.count {
+					return nil, errorf("count(*) can't be mixed with other projections")
+				}
+				val, err := proj.val(&evalCtx{db: p.db, rec: rec, vars: vars})
+				if err != nil {
+					return nil, err
+				}
+				row[proj.name] = val
+			}
+			ret = append(ret, row)
+		}
+		return ret, nil
+	}, nil
+}
+
+func sortRecords(records []*fakeRecord, orderBy []string, desc []bool) {
+	less := func(i, j int) bool {
+		for ind, field := range orderBy {
+			cmp, _ := compare(lookup(records[i], field), lookup(records[j], field))
+			if cmp != 0 {
+				return (cmp < 0) != desc[ind]
+			}
+		}
+		return false
+	}
+	for i := 1; i < len(records); i++ { // insertion sort keeps it stable
+		for j := i; j > 0 && less(j, j-1); j-- {
+			records[j], records[j-1] = records[j-1], records[j]
+		}
+	}
+}
+
+// fieldsClause parses SET assignments or CONTENT document of a new record.
+func (p *parser) fieldsClause() (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	switch {
+	case p.acceptKw("CONTENT"):
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		content, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, errorf("CONTENT should be a JSON object in %q", p.src)
+		}
+		return stripMeta(content), nil
+	case p.acceptKw("SET"):
+		for {
+			field, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expectPunct("="); err != nil {
+				return nil, err
+			}
+			val, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			setPath(fields, field, val)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	return fields, nil
+}
+
+func setPath(fields map[string]interface{}, path string, val interface{}) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		sub, ok := fields[name].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			fields[name] = sub
+		}
+		fields = sub
+	}
+	fields[names[len(names)-1]] = val
+}
+
+func removePath(fields map[string]interface{}, path string) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		sub, ok := fields[name].(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields = sub
+	}
+	delete(fields, names[len(names)-1])
+}
+
+func countResult(n int) []interface{} {
+	return []interface{}{map[string]interface{}{"@type": "d", "@version": 0, "value": float64(n)}}
+}
+
+/* exec runs single statement, storing results of LET statements in vars. Returned is true for RETURN statement,
+which ends the script. */
+func (db *fakeDB) exec(text string, vars map[string][]interface{}) ([]interface{}, bool, error) {
+	toks, err := lex(text)
+	if err != nil {
+		return nil, false, err
+	}
+	p := &parser{src: text, toks: toks, db: db, vars: vars}
+	var res []interface{}
+	returned := false
+	switch {
+	case p.acceptKw("LET"):
+		name, err := p.ident()
+		if err != nil {
+			return nil, false, err
+		}
+		if err = p.expectPunct("="); err != nil {
+			return nil, false, err
+		}
+		inner := p.rest()
+		if res, _, err = db.exec(inner, vars); err != nil {
+			return nil, false, err
+		}
+		vars[strings.TrimPrefix(name, "$")] = res
+	case p.acceptKw("RETURN"):
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, false, err
+		}
+		res, returned = members(val), true
+	case p.isKw("BEGIN"), p.isKw("COMMIT"), p.isKw("ROLLBACK"):
+		p.rest()
+	case p.isKw("SELECT"):
+		sel, err := p.parseSelect()
+		if err != nil {
+			return nil, false, err
+		}
+		res, err = sel(vars)
+		if err != nil {
+			return nil, false, err
+		}
+	case p.acceptKw("INSERT", "INTO"):
+		res, err = p.execInsert()
+	case p.acceptKw("CREATE", "VERTEX"):
+		res, err = p.execCreateVertex()
+	case p.acceptKw("CREATE", "EDGE"):
+		res, err = p.execCreateEdge()
+	case p.acceptKw("UPDATE"):
+		res, err = p.execUpdate()
+	case p.acceptKw("DELETE"):
+		res, err = p.execDelete()
+	case p.acceptKw("CREATE", "CLASS"):
+		res, err = p.execCreateClass()
+	case p.acceptKw("ALTER", "CLASS"):
+		res, err = p.execAlterClass()
+	case p.acceptKw("DROP", "CLASS"):
+		res, err = p.execDropClass()
+	case p.acceptKw("CREATE", "PROPERTY"):
+		res, err = p.execCreateProperty()
+	case p.acceptKw("ALTER", "PROPERTY"):
+		res, err = p.execAlterProperty()
+	case p.acceptKw("DROP", "PROPERTY"):
+		res, err = p.execDropProperty()
+	case p.acceptKw("CREATE", "INDEX"):
+		res, err = p.execCreateIndex()
+	case p.acceptKw("DROP", "INDEX"):
+		res, err = p.execDropIndex()
+	default:
+		return nil, false, errorf("Cannot find a command executor for the command request: sql.%s", text)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	p.acceptPunct(";")
+	if p.peek().kind != tkEOF {
+		return nil, false, p.unexpected("end of statement")
+	}
+	return res, returned, nil
+}
+
+func (p *parser) execInsert() ([]interface{}, error) {
+	class, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := p.fieldsClause()
+	if err != nil {
+		return nil, err
+	}
+	if p.db.isA(class, "E") {
+		return nil, errorf("'INSERT' command cannot create Edges, use 'CREATE EDGE' instead")
+	}
+	rec, err := p.db.insert(class, fields)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{rec.toJson()}, nil
+}
+
+func (p *parser) execCreateVertex() ([]interface{}, error) {
+	class := "V"
+	if p.peek().kind == tkIdent && !p.isKw("SET") && !p.isKw("CONTENT") {
+		class = p.next().text
+	}
+	if !p.db.isA(class, "V") {
+		return nil, errorf("Class '%s' is not a Vertex type", class)
+	}
+	fields, err := p.fieldsClause()
+	if err != nil {
+		return nil, err
+	}
+	rec, err := p.db.insert(class, fields)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{rec.toJson()}, nil
+}
+
+func (p *parser) execCreateEdge() ([]interface{}, error) {
+	class := "E"
+	if !p.isKw("FROM") {
+		class = p.next().text
+	}
+	if !p.db.isA(class, "E") {
+		return nil, errorf("Class '%s' is not an Edge type", class)
+	}
+	if err := p.expectKw("FROM"); err != nil {
+		return nil, err
+	}
+	from, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expectKw("TO"); err != nil {
+		return nil, err
+	}
+	to, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := p.fieldsClause()
+	if err != nil {
+		return nil, err
+	}
+	froms, err := from(p.vars)
+	if err != nil {
+		return nil, err
+	}
+	tos, err := to(p.vars)
+	if err != nil {
+		return nil, err
+	}
+	if len(froms) == 0 || len(tos) == 0 {
+		return nil, errorf("Source or destination vertex of the edge not found in %q", p.src)
+	}
+	ret := []interface{}{}
+	for _, out := range froms {
+		for _, in := range tos {
+			if !p.db.isA(out.class, "V") || !p.db.isA(in.class, "V") {
+				return nil, errorf("Edges can only connect vertexes, in %q", p.src)
+			}
+			edgeFields := deepCopy(fields).(map[string]interface{})
+			edgeFields["out"], edgeFields["in"] = out.rid(), in.rid()
+			e, err := p.db.insert(class, edgeFields)
+			if err != nil {
+				return nil, err
+			}
+			for _, end := range []struct {
+				rid, field string
+			}{{out.rid(), "out_" + relSuffix(e.class)}, {in.rid(), "in_" + relSuffix(e.class)}} {
+				vtx := p.db.records[end.rid].copy()
+				rels, _ := vtx.fields[end.field].([]interface{})
+				vtx.fields[end.field] = append(rels, e.rid())
+				vtx.version++
+				p.db.records[end.rid] = vtx
+			}
+			ret = append(ret, e.toJson())
+		}
+	}
+	return ret, nil
+}
+
+// update is a single operation of UPDATE statement applied to a record.
+type update func(rec *fakeRecord, ctx *evalCtx) error
+
+// assignments parses comma-separated "field = value" list, calling fn for each of them.
+func (p *parser) assignments(optionalValue bool, fn func(field string, val expr) update) ([]update, error) {
+	var ret []update
+	for {
+		field, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		var val expr
+		if p.acceptPunct("=") {
+			if val, err = p.parseOr(); err != nil {
+				return nil, err
+			}
+		} else if !optionalValue {
+			return nil, p.unexpected("=")
+		}
+		ret = append(ret, fn(field, val))
+		if !p.acceptPunct(",") {
+			return ret, nil
+		}
+	}
+}
+
+func (p *parser) execUpdate() ([]interface{}, error) {
+	targetTok := p.peek()
+	from, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	var updates []update
+	var cond expr
+	var upsert bool
+	var returnMode string
+	var returnProj expr
+	limit := -1
+	for p.peek().kind != tkEOF && !p.isPunct(";") {
+		var clauseUpdates []update
+		switch {
+		case p.acceptKw("SET"):
+			clauseUpdates, err = p.assignments(false, func(field string, val expr) update {
+				return func(rec *fakeRecord, ctx *evalCtx) error {
+					v, err := val(ctx)
+					if err == nil {
+						setPath(rec.fields, field, v)
+					}
+					return err
+				}
+			})
+		case p.acceptKw("REMOVE"):
+			clauseUpdates, err = p.assignments(true, func(field string, val expr) update {
+				return func(rec *fakeRecord, ctx *evalCtx) error {
+					if val == nil {
+						removePath(rec.fields, field)
+						return nil
+					}
+					v, err := val(ctx)
+					if err != nil {
+						return err
+					}
+					switch cur := lookup(rec, field).(type) {
+					case []interface{}:
+						var kept []interface{}
+						for _, elem := range cur {
+							if !equal(elem, v) {
+								kept = append(kept, elem)
+							}
+						}
+						if kept == nil {
+							kept = []interface{}{}
+						}
+						setPath(rec.fields, field, kept)
+					case map[string]interface{}:
+						delete(cur, fmt.Sprint(v))
+						setPath(rec.fields, field, cur)
+					}
+					return nil
+				}
+			})
+		case p.acceptKw("INCREMENT"):
+			clauseUpdates, err = p.assignments(false, func(field string, val expr) update {
+				return func(rec *fakeRecord, ctx *evalCtx) error {
+					v, err := val(ctx)
+					if err != nil {
+						return err
+					}
+					cur, present := lookup(rec, field), lookup(rec, field) != nil
+					if !present {
+						setPath(rec.fields, field, v)
+						return nil
+					}
+					ci, curInt := cur.(int64)
+					vi, valInt := v.(int64)
+					cf, curOk := toFloat(cur)
+					vf, valOk := toFloat(v)
+					switch {
+					case curInt && valInt:
+						setPath(rec.fields, field, ci+vi)
+					case curOk && valOk:
+						setPath(rec.fields, field, cf+vf)
+					default:
+						return errorf("Cannot increment non-numeric field %s", field)
+					}
+					return nil
+				}
+			})
+		case p.acceptKw("ADD"):
+			clauseUpdates, err = p.assignments(false, func(field string, val expr) update {
+				return func(rec *fakeRecord, ctx *evalCtx) error {
+					v, err := val(ctx)
+					if err != nil {
+						return err
+					}
+					cur, _ := lookup(rec, field).([]interface{})
+					setPath(rec.fields, field, append(cur, v))
+					return nil
+				}
+			})
+		case p.acceptKw("PUT"):
+			for {
+				field, err := p.path()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expectPunct("="); err != nil {
+					return nil, err
+				}
+				key, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expectPunct(","); err != nil {
+					return nil, err
+				}
+				val, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				updates = append(updates, func(rec *fakeRecord, ctx *evalCtx) error {
+					k, err := key(ctx)
+					if err != nil {
+						return err
+					}
+					v, err := val(ctx)
+					if err != nil {
+						return err
+					}
+					cur, ok := lookup(rec, field).(map[string]interface{})
+					if !ok {
+						cur = make(map[string]interface{})
+					}
+					cur[fmt.Sprint(k)] = v
+					setPath(rec.fields, field, cur)
+					return nil
+				})
+				if !p.isPunct(",") || p.toks[p.pos+1].kind != tkIdent || p.toks[p.pos+2].text != "=" {
+					break
+				}
+				p.pos++
+			}
+		case p.isKw("MERGE"), p.isKw("CONTENT"):
+			merge := p.acceptKw("MERGE")
+			if !merge {
+				p.pos++
+			}
+			doc, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			clauseUpdates = []update{func(rec *fakeRecord, ctx *evalCtx) error {
+				v, err := doc(ctx)
+				if err != nil {
+					return err
+				}
+				m, ok := v.(map[string]interface{})
+				if !ok {
+					return errorf("MERGE and CONTENT need a JSON object")
+				}
+				if !merge {
+					for name := range rec.fields {
+						if !isGraphField(name) {
+							delete(rec.fields, name)
+						}
+					}
+				}
+				for name, val := range stripMeta(m) {
+					rec.fields[name] = val
+				}
+				return nil
+			}}
+		case p.acceptKw("UPSERT"):
+			upsert = true
+		case p.acceptKw("RETURN"):
+			switch {
+			case p.acceptKw("AFTER"):
+				returnMode = "AFTER"
+			case p.acceptKw("BEFORE"):
+				returnMode = "BEFORE"
+			case p.acceptKw("COUNT"):
+				returnMode = ""
+			default:
+				return nil, p.unexpected("AFTER, BEFORE or COUNT")
+			}
+			if tok := p.peek(); returnMode != "" && tok.kind != tkEOF && !p.isPunct(";") &&
+				!(tok.kind == tkIdent && clauseKeywords[strings.ToUpper(tok.text)]) {
+				if returnProj, err = p.parsePrimary(); err != nil {
+					return nil, err
+				}
+			}
+		case p.isKw("WHERE"):
+			if cond, err = p.where(); err != nil {
+				return nil, err
+			}
+		case p.acceptKw("LIMIT"):
+			val, err := p.parseValue()
+			f, ok := toFloat(val)
+			if err != nil || !ok {
+				return nil, p.unexpected("number")
+			}
+			limit = int(f)
+		default:
+			return nil, p.unexpected("UPDATE clause")
+		}
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, clauseUpdates...)
+	}
+
+	records, err := from(p.vars)
+	if err != nil {
+		return nil, err
+	}
+	if records, err = p.filter(records, cond, p.vars); err != nil {
+		return nil, err
+	}
+	if limit >= 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	ret := []interface{}{}
+	if len(records) == 0 && upsert {
+		if targetTok.kind != tkIdent {
+			return nil, errorf("UPSERT needs a class as target in %q", p.src)
+		}
+		fields, err := p.whereEqualities()
+		if err != nil {
+			return nil, err
+		}
+		rec := &fakeRecord{class: targetTok.text, fields: fields}
+		for _, upd := range updates {
+			if err := upd(rec, &evalCtx{db: p.db, rec: rec, vars: p.vars}); err != nil {
+				return nil, err
+			}
+		}
+		created, err := p.db.insert(targetTok.text, rec.fields)
+		if err != nil {
+			return nil, err
+		}
+		if returnMode == "" {
+			return countResult(1), nil
+		}
+		return []interface{}{p.returned(created, returnProj)}, nil
+	}
+	for _, rec := range records {
+		before := rec
+		updated := rec.copy()
+		for _, upd := range updates {
+			if err := upd(updated, &evalCtx{db: p.db, rec: updated, vars: p.vars}); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.db.save(updated); err != nil {
+			return nil, err
+		}
+		switch returnMode {
+		case "AFTER":
+			ret = append(ret, p.returned(updated, returnProj))
+		case "BEFORE":
+			ret = append(ret, p.returned(before, returnProj))
+		}
+	}
+	if returnMode == "" {
+		return countResult(len(records)), nil
+	}
+	return ret, nil
+}
+
+// returned renders record returned by UPDATE ... RETURN, which can be projected to a single value.
+func (p *parser) returned(rec *fakeRecord, proj expr) interface{} {
+	if proj == nil {
+		return rec.toJson()
+	}
+	val, err := proj(&evalCtx{db: p.db, rec: rec, vars: p.vars})
+	if err != nil {
+		val = nil
+	}
+	return map[string]interface{}{"@type": "d", "@version": 0, "value": val}
+}
+
+/* whereEqualities returns fields of a record created by UPSERT: ones compared for equality in WHERE clause of the
+statement, which has to be a conjunction of such comparisons. */
+func (p *parser) whereEqualities() (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	start := -1
+	for ind, tok := range p.toks {
+		if tok.kind == tkIdent && strings.EqualFold(tok.text, "WHERE") {
+			start = ind + 1
+		}
+	}
+	sub := &parser{src: p.src, toks: p.toks, pos: start, db: p.db, vars: p.vars}
+	for start != -1 {
+		field, err := sub.path()
+		if err != nil {
+			return nil, err
+		}
+		if err = sub.expectPunct("="); err != nil {
+			return nil, errorf("UPSERT needs WHERE with equality conditions joined with AND, in %q", p.src)
+		}
+		prim, err := sub.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		val, err := prim(&evalCtx{db: p.db, vars: p.vars})
+		if err != nil {
+			return nil, err
+		}
+		setPath(fields, field, val)
+		if !sub.acceptKw("AND") {
+			break
+		}
+	}
+	return fields, nil
+}
+
+func (p *parser) execDelete() ([]interface{}, error) {
+	kind := ""
+	switch {
+	case p.acceptKw("VERTEX"):
+		kind = "V"
+	case p.acceptKw("EDGE"):
+		kind = "E"
+	case p.acceptKw("FROM"):
+	default:
+		return nil, p.unexpected("VERTEX, EDGE or FROM")
+	}
+	from, err := p.target()
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.where()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKw("LIMIT") {
+		p.parseValue()
+	}
+	p.acceptKw("UNSAFE")
+	records, err := from(p.vars)
+	if err != nil {
+		return nil, err
+	}
+	if records, err = p.filter(records, cond, p.vars); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if kind != "" && !p.db.isA(rec.class, kind) {
+			return nil, errorf("Record %s is not %s", rec.rid(), map[string]string{"V": "a vertex", "E": "an edge"}[kind])
+		}
+	}
+	for _, rec := range records {
+		p.db.remove(rec.rid())
+	}
+	return countResult(len(records)), nil
+}
+
+func (p *parser) execCreateClass() ([]interface{}, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	ifNotExists := p.acceptKw("IF", "NOT", "EXISTS")
+	cl := sheikh.Class{Name: name}
+	if p.acceptKw("EXTENDS") {
+		for {
+			super, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if p.db.class(super) == nil {
+				return nil, errorf("Super-class %s not exists", super)
+			}
+			cl.SuperClasses = append(cl.SuperClasses, p.db.class(super).Name)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKw("CLUSTER") {
+		for p.peek().kind == tkNumber || p.isPunct(",") {
+			p.pos++
+		}
+	}
+	cl.Abstract = p.acceptKw("ABSTRACT")
+	if existing := p.db.class(name); existing != nil {
+		if ifNotExists {
+			return countResult(existing.cluster), nil
+		}
+		return nil, errorf("Class %s already exists in current database", name)
+	}
+	fc := p.db.addClass(cl)
+	return countResult(fc.cluster), nil
+}
+
+func (p *parser) execAlterClass() ([]interface{}, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	fc := p.db.class(name)
+	if fc == nil {
+		return nil, errorf("Class '%s' was not found in current database", name)
+	}
+	attr, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	value := p.rest()
+	switch strings.ToUpper(attr) {
+	case "SUPERCLASSES", "SUPERCLASS":
+		fc.SuperClasses = nil
+		if !strings.EqualFold(value, "null") {
+			for _, super := range strings.Split(value, ",") {
+				super = strings.TrimSpace(super)
+				if p.db.class(super) == nil {
+					return nil, errorf("Super-class %s not exists", super)
+				}
+				fc.SuperClasses = append(fc.SuperClasses, p.db.class(super).Name)
+			}
+		}
+	case "ABSTRACT":
+		fc.Abstract = strings.EqualFold(value, "true")
+	case "NAME":
+		delete(p.db.classes, strings.ToLower(fc.Name))
+		fc.Name = value
+		p.db.classes[strings.ToLower(value)] = fc
+	}
+	return countResult(1), nil
+}
+
+func (p *parser) execDropClass() ([]interface{}, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	ifExists := p.acceptKw("IF", "EXISTS")
+	unsafe := p.acceptKw("UNSAFE")
+	fc := p.db.class(name)
+	if fc == nil {
+		if ifExists {
+			return countResult(0), nil
+		}
+		return nil, errorf("Class '%s' was not found in current database", name)
+	}
+	for _, other := range p.db.classes {
+		for _, super := range other.SuperClasses {
+			if strings.EqualFold(super, fc.Name) {
+				return nil, errorf("Class '%s' cannot be dropped because it has sub classes", fc.Name)
+			}
+		}
+	}
+	var own []*fakeRecord
+	for _, rec := range p.db.records {
+		if rec.class == fc.Name {
+			own = append(own, rec)
+		}
+	}
+	if len(own) != 0 && !unsafe && (p.db.isA(fc.Name, "V") || p.db.isA(fc.Name, "E")) {
+		return nil, errorf("'DROP CLASS' command cannot drop class '%s' because it contains Vertices or Edges. Use 'DELETE VERTEX/EDGE' command first to avoid broken edges in a database, or apply the 'UNSAFE' keyword to force it",
+			fc.Name)
+	}
+	for _, rec := range own {
+		delete(p.db.records, rec.rid())
+	}
+	var kept []sheikh.Index
+	for _, idx := range p.db.indexes {
+		if !strings.EqualFold(idx.Class, fc.Name) {
+			kept = append(kept, idx)
+		}
+	}
+	p.db.indexes = kept
+	delete(p.db.classes, strings.ToLower(fc.Name))
+	return countResult(1), nil
+}
+
+// classAndProperty parses Class.property name.
+func (p *parser) classAndProperty() (*fakeClass, string, error) {
+	class, err := p.ident()
+	if err != nil {
+		return nil, "", err
+	}
+	if err = p.expectPunct("."); err != nil {
+		return nil, "", err
+	}
+	prop, err := p.ident()
+	if err != nil {
+		return nil, "", err
+	}
+	fc := p.db.class(class)
+	if fc == nil {
+		return nil, "", errorf("Class '%s' was not found in current database", class)
+	}
+	return fc, prop, nil
+}
+
+func (p *parser) execCreateProperty() ([]interface{}, error) {
+	fc, name, err := p.classAndProperty()
+	if err != nil {
+		return nil, err
+	}
+	ifNotExists := p.acceptKw("IF", "NOT", "EXISTS")
+	odbType, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	prop := sheikh.Property{Name: name, Type: strings.ToUpper(odbType)}
+	if p.peek().kind == tkIdent {
+		linked := p.next().text
+		if _, isType := map[string]bool{"STRING": true, "INTEGER": true, "LONG": true, "SHORT": true, "BYTE": true,
+			"BOOLEAN": true, "FLOAT": true, "DOUBLE": true, "DECIMAL": true, "DATE": true, "DATETIME": true,
+			"LINK": true, "EMBEDDED": true, "BINARY": true}[strings.ToUpper(linked)]; isType {
+			prop.LinkedType = strings.ToUpper(linked)
+		} else {
+			prop.LinkedClass = linked
+		}
+	}
+	for _, existing := range fc.Properties {
+		if strings.EqualFold(existing.Name, name) {
+			if ifNotExists {
+				return countResult(len(fc.Properties)), nil
+			}
+			return nil, errorf("Property '%s.%s' already exists", fc.Name, name)
+		}
+	}
+	fc.Properties = append(fc.Properties, prop)
+	return countResult(len(fc.Properties)), nil
+}
+
+func (p *parser) execAlterProperty() ([]interface{}, error) {
+	fc, name, err := p.classAndProperty()
+	if err != nil {
+		return nil, err
+	}
+	attr, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	value := p.rest()
+	isNull := strings.EqualFold(value, "null")
+	for ind := range fc.Properties {
+		prop := &fc.Properties[ind]
+		if !strings.EqualFold(prop.Name, name) {
+			continue
+		}
+		switch strings.ToUpper(attr) {
+		case "MANDATORY":
+			prop.Mandatory = strings.EqualFold(value, "true")
+		case "NOTNULL":
+			prop.NotNull = strings.EqualFold(value, "true")
+		case "READONLY":
+			prop.ReadOnly = strings.EqualFold(value, "true")
+		case "MIN", "MAX", "DEFAULT", "REGEXP", "LINKEDCLASS", "LINKEDTYPE", "TYPE":
+			if isNull {
+				value = ""
+			} else if str, length, err := unquote(value); err == nil && length == len(value) {
+				value = str
+			}
+			switch strings.ToUpper(attr) {
+			case "MIN":
+				prop.Min = value
+			case "MAX":
+				prop.Max = value
+			case "DEFAULT":
+				prop.Default = value
+			case "REGEXP":
+				prop.Regexp = value
+			case "LINKEDCLASS":
+				prop.LinkedClass = value
+			case "LINKEDTYPE":
+				prop.LinkedType = strings.ToUpper(value)
+			case "TYPE":
+				prop.Type = strings.ToUpper(value)
+			}
+		default:
+			return nil, errorf("Unsupported property attribute %s in fake server", attr)
+		}
+		return countResult(1), nil
+	}
+	return nil, errorf("Property '%s.%s' not exists", fc.Name, name)
+}
+
+func (p *parser) execDropProperty() ([]interface{}, error) {
+	fc, name, err := p.classAndProperty()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptKw("FORCE")
+	for ind, prop := range fc.Properties {
+		if strings.EqualFold(prop.Name, name) {
+			fc.Properties = append(fc.Properties[:ind], fc.Properties[ind+1:]...)
+			return countResult(1), nil
+		}
+	}
+	return nil, errorf("Property '%s.%s' not exists", fc.Name, name)
+}
+
+func (p *parser) execCreateIndex() ([]interface{}, error) {
+	name, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	ifNotExists := p.acceptKw("IF", "NOT", "EXISTS")
+	idx := sheikh.Index{Name: name}
+	if p.acceptKw("ON") {
+		if idx.Class, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err = p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		for !p.acceptPunct(")") {
+			if len(idx.Fields) != 0 {
+				if err = p.expectPunct(","); err != nil {
+					return nil, err
+				}
+			}
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			idx.Fields = append(idx.Fields, field)
+		}
+	} else if dot := strings.Index(name, "."); dot != -1 {
+		idx.Class, idx.Fields = name[:dot], []string{name[dot+1:]}
+	} else {
+		return nil, p.unexpected("ON")
+	}
+	if idx.Type, err = p.ident(); err != nil {
+		return nil, err
+	}
+	idx.Type = strings.ToUpper(idx.Type)
+	p.rest() // engine and metadata
+	if fc := p.db.class(idx.Class); fc == nil {
+		return nil, errorf("Class '%s' was not found in current database", idx.Class)
+	} else {
+		idx.Class = fc.Name
+	}
+	for _, existing := range p.db.indexes {
+		if strings.EqualFold(existing.Name, idx.Name) {
+			if ifNotExists {
+				return countResult(0), nil
+			}
+			return nil, errorf("Index with name %s already exists", idx.Name)
+		}
+	}
+	p.db.indexes = append(p.db.indexes, idx)
+	for _, rec := range p.db.scan(idx.Class) {
+		if err := p.db.checkIndexes(rec); err != nil {
+			p.db.indexes = p.db.indexes[:len(p.db.indexes)-1]
+			return nil, err
+		}
+	}
+	return countResult(len(p.db.scan(idx.Class))), nil
+}
+
+func (p *parser) execDropIndex() ([]interface{}, error) {
+	name, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptKw("IF", "EXISTS")
+	for ind, idx := range p.db.indexes {
+		if strings.EqualFold(idx.Name, name) {
+			p.db.indexes = append(p.db.indexes[:ind], p.db.indexes[ind+1:]...)
+			return countResult(1), nil
+		}
+	}
+	return countResult(0), nil
+}
@@ -0,0 +1,139 @@
+package sheikhtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sheikh"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Matcher tells whether a request (given as Exchange without the response part) matches an exchange from the
+recording. */
+type Matcher func(req, recorded *sheikh.Exchange) bool
+
+// MatchRequest matches requests with the same method, URL and body. It's used when Replayer has no matchers.
+func MatchRequest(req, recorded *sheikh.Exchange) bool {
+	return req.Method == recorded.Method && req.URL == recorded.URL && req.Body == recorded.Body
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+/* MatchSQL matches commands and batches with the same SQL, ignoring differences in whitespace, so they match even if
+the way it's sent changes (e.g. limit of the command). Other requests are matched with MatchRequest. */
+func MatchSQL(req, recorded *sheikh.Exchange) bool {
+	if req.SQL == "" || recorded.SQL == "" {
+		return MatchRequest(req, recorded)
+	}
+	return req.Method == recorded.Method &&
+		spaces.ReplaceAllString(strings.TrimSpace(req.SQL), " ") ==
+			spaces.ReplaceAllString(strings.TrimSpace(recorded.SQL), " ")
+}
+
+/* Replayer is http.RoundTripper serving responses from a recording made with Connection.Record, without contacting
+the server. Each recorded exchange is served once, in order of the recording, for the first request it matches;
+requests not matching any remaining exchange fail and are listed by Unmatched. */
+type Replayer struct {
+	Recording *sheikh.Recording
+	Matchers  []Matcher // the request matches an exchange if any of them accepts it
+
+	mu        sync.Mutex
+	used      []bool
+	unmatched []string
+}
+
+// NewReplayer loads recording from the file, to be served according to given matchers (MatchRequest if none).
+func NewReplayer(path string, matchers ...Matcher) (*Replayer, error) {
+	rec, err := sheikh.LoadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		matchers = []Matcher{MatchRequest}
+	}
+	return &Replayer{Recording: rec, Matchers: matchers, used: make([]bool, len(rec.Exchanges))}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	sent := &sheikh.Exchange{Method: req.Method, URL: req.URL.RequestURI(), SQL: sheikh.ExchangeSQL(req, body),
+		Body: string(body)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ind := range r.Recording.Exchanges {
+		ex := &r.Recording.Exchanges[ind]
+		if r.used[ind] || !r.matches(sent, ex) {
+			continue
+		}
+		r.used[ind] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%v %s", ex.Status, http.StatusText(ex.Status)),
+			StatusCode:    ex.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        ex.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(ex.Response))),
+			ContentLength: int64(len(ex.Response)),
+			Request:       req,
+		}, nil
+	}
+	desc := sent.Method + " " + sent.URL
+	if sent.SQL != "" {
+		desc = fmt.Sprintf("%s %s (%q)", sent.Method, strings.SplitN(sent.URL, "/sql/", 2)[0], sent.SQL)
+	}
+	r.unmatched = append(r.unmatched, desc)
+	return nil, errors.New(fmt.Sprintf("Replay: request %s was not recorded", desc))
+}
+
+func (r *Replayer) matches(req, recorded *sheikh.Exchange) bool {
+	for _, matcher := range r.Matchers {
+		if matcher(req, recorded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmatched returns descriptions of requests which weren't found in the recording.
+func (r *Replayer) Unmatched() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.unmatched...)
+}
+
+/* Replay returns connection served by Replayer from the recording at path, so the test runs offline and gets the
+same responses every time. The connection is connected if the recording starts with connecting. The test fails if
+any request wasn't recorded. */
+func Replay(t testing.TB, path string, matchers ...Matcher) *sheikh.Connection {
+	t.Helper()
+	r, err := NewReplayer(path, matchers...)
+	if err != nil {
+		t.Fatalf("sheikhtest: %v", err)
+	}
+	c := sheikh.NewConnection("replay", r.Recording.Database, Username, Password)
+	c.Client.Transport = r
+	t.Cleanup(func() {
+		for _, desc := range r.Unmatched() {
+			t.Errorf("sheikhtest: request %s was not recorded in %s", desc, path)
+		}
+	})
+	if exs := r.Recording.Exchanges; len(exs) != 0 && strings.HasPrefix(exs[0].URL, "/connect/") {
+		if err = c.Connect(); err != nil {
+			t.Fatalf("sheikhtest: %v", err)
+		}
+	}
+	return &c
+}
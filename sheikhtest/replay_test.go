@@ -0,0 +1,84 @@
+package sheikhtest
+
+import (
+	"os"
+	"path/filepath"
+	"sheikh"
+	"testing"
+)
+
+// gophers inserts two vertexes connected with an edge and returns name of the vertex the edge points to.
+func gophers(t *testing.T, c *sheikh.Connection) string {
+	if _, err := c.Command("CREATE CLASS Gopher EXTENDS V"); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := sheikh.NewVertex("Gopher"), sheikh.NewVertex("Gopher")
+	alice.SetProps("name", "Alice")
+	bob.SetProps("name", "Bob")
+	if err := c.InsertVertex(&alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertVertex(&bob); err != nil {
+		t.Fatal(err)
+	}
+	e := sheikh.CreateEdge(&alice, "E", &bob)
+	if err := c.InsertEdge(&e); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := c.SelectVertexes("Gopher", 0, "WHERE in_ IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 1 {
+		t.Fatalf("%v vertexes have incoming edges", len(vs))
+	}
+	return vs[0].PropRequireStr("name")
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gophers.json")
+	fs := NewFakeServer("recorded", Username, Password)
+	c := fs.Connection()
+	if err := c.Record(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := sheikh.LoadRecording(path); err != nil || len(rec.Exchanges) != 1 { // readable while recording
+		t.Errorf("recording of Connect is %v, error %v", rec, err)
+	}
+	recorded := gophers(t, &c)
+	c.Record("")
+	fs.Close()
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, matcher := range []Matcher{MatchRequest, MatchSQL} {
+		replayed := Replay(t, path, matcher)
+		if replayed.Database != "recorded" {
+			t.Errorf("replayed connection uses database %s", replayed.Database)
+		}
+		if name := gophers(t, replayed); name != recorded {
+			t.Errorf("replay returned %s instead of %s", name, recorded)
+		}
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(written) {
+		t.Errorf("recording changed after it was stopped")
+	}
+
+	r, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := sheikh.NewConnection("replay", "recorded", Username, Password)
+	replayed.Client.Transport = r
+	if _, err = replayed.Command("SELECT FROM Gopher WHERE name = 'Carol'"); err == nil {
+		t.Errorf("request which wasn't recorded succeeded")
+	}
+	if unmatched := r.Unmatched(); len(unmatched) != 1 {
+		t.Errorf("Unmatched returned %q", unmatched)
+	}
+}
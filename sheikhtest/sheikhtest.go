@@ -0,0 +1,134 @@
+/* Package sheikhtest creates ephemeral OrientDB databases for tests. Each call to New creates a uniquely named
+in-memory database, so tests using it can run in parallel, and drops it when the test finishes:
+   func TestGophers(t *testing.T) {
+      t.Parallel()
+      c := sheikhtest.New(t, sheikhtest.Script("CREATE CLASS Gopher EXTENDS V"))
+      ...
+   }
+The server is configured with SHEIKH_TEST_SERVER, SHEIKH_TEST_PORT, SHEIKH_TEST_SERVER_USER and
+SHEIKH_TEST_SERVER_PASSWORD environment variables; tests are skipped when the password isn't set. */
+package sheikhtest
+
+import (
+	"fmt"
+	"os"
+	"sheikh"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode"
+)
+
+// Settings of the server used for test databases; they're read from the environment, but can be changed by tests.
+var (
+	Server         = envOr("SHEIKH_TEST_SERVER", "localhost")
+	Port           = envOr("SHEIKH_TEST_PORT", "2480")
+	ServerUsername = envOr("SHEIKH_TEST_SERVER_USER", "root")
+	ServerPassword = os.Getenv("SHEIKH_TEST_SERVER_PASSWORD")
+	// Login of the default user of newly created databases.
+	Username, Password = "admin", "admin"
+)
+
+// Fixture prepares a fresh test database, e.g. creates classes or inserts records.
+type Fixture func(c *sheikh.Connection) error
+
+var counter int64
+
+func envOr(name, fallback string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return fallback
+}
+
+// dbName returns database name unique for the test and the process.
+func dbName(t testing.TB) string {
+	name := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return r
+	}, t.Name())
+	return fmt.Sprintf("sheikhtest_%s_%v_%v", name, os.Getpid(), atomic.AddInt64(&counter, 1))
+}
+
+/* New creates an in-memory graph database for the test, runs fixtures on it and returns connection to it. The
+database is dropped when the test and its subtests finish. The test fails immediately if the database can't be
+prepared, and is skipped if server password isn't configured. */
+func New(t testing.TB, fixtures ...Fixture) *sheikh.Connection {
+	t.Helper()
+	if ServerPassword == "" {
+		t.Skip("sheikhtest: SHEIKH_TEST_SERVER_PASSWORD not set, skipping test needing a database")
+	}
+	name := dbName(t)
+	c := sheikh.NewConnection(Server, name, Username, Password)
+	c.Port = Port
+	c.ServerUsername, c.ServerPassword = ServerUsername, ServerPassword
+	if err := c.CreateDatabase(name, "memory", "graph"); err != nil {
+		t.Fatalf("sheikhtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.DropDatabase(name); err != nil {
+			t.Errorf("sheikhtest: %v", err)
+		}
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("sheikhtest: %v", err)
+	}
+	for ind, fixture := range fixtures {
+		if err := fixture(&c); err != nil {
+			t.Fatalf("sheikhtest: fixture %v failed: %v", ind, err)
+		}
+	}
+	return &c
+}
+
+// Schema returns fixture applying the schema to the database.
+func Schema(s *sheikh.Schema) Fixture {
+	return func(c *sheikh.Connection) error {
+		_, err := s.Apply(c)
+		return err
+	}
+}
+
+// SchemaFile returns fixture applying schema loaded from JSON file (see sheikh.LoadSchema).
+func SchemaFile(path string) Fixture {
+	return func(c *sheikh.Connection) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		s, err := sheikh.LoadSchema(f)
+		if err != nil {
+			return err
+		}
+		_, err = s.Apply(c)
+		return err
+	}
+}
+
+/* Script returns fixture running SQL statements one by one, outside of transaction, so they can change the schema
+too. */
+func Script(statements ...string) Fixture {
+	return func(c *sheikh.Connection) error {
+		for _, comText := range statements {
+			if _, err := c.Command(comText); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Vertexes returns fixture inserting the vertexes; their RIDs are set, so tests can refer to them afterwards.
+func Vertexes(vs ...*sheikh.Vertex) Fixture {
+	return func(c *sheikh.Connection) error {
+		for _, v := range vs {
+			if err := c.InsertVertex(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,39 @@
+package sheikhtest
+
+import (
+	"sheikh"
+	"testing"
+)
+
+func TestIsolatedDatabases(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			v := sheikh.NewVertex("Gopher")
+			v.SetProps("name", name)
+			c := New(t, Script("CREATE CLASS Gopher EXTENDS V"), Vertexes(&v))
+			vs, err := c.SelectVertexes("Gopher", 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vs) != 1 || vs[0].PropRequireStr("name") != name {
+				t.Errorf("database of %s test contains other vertexes: %v", name, vs)
+			}
+		})
+	}
+}
+
+func TestDbName(t *testing.T) {
+	first, second := dbName(t), dbName(t)
+	if first == second {
+		t.Errorf("dbName returned %s twice", first)
+	}
+	t.Run("sub/test name", func(t *testing.T) {
+		for _, r := range dbName(t) {
+			if r == '/' || r == ' ' {
+				t.Errorf("dbName(%q) contains %q", t.Name(), r)
+			}
+		}
+	})
+}
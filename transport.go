@@ -0,0 +1,303 @@
+package sheikh
+
+import (
+	"bytes"
+	"chillson"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Transport carries requests of Connection to the database. RESTTransport, using OrientDB REST API, is the default;
+BinaryTransport speaks OrientDB binary protocol, and other implementations (e.g. test doubles) can be set in
+Connection.Transport. Results are JSON-like values, with records given as maps, as they are returned by the REST API. */
+type Transport interface {
+	// Authenticate opens session with the database, using credentials of the connection.
+	Authenticate(c *Connection) error
+	// Command runs SQL command and returns records it produced.
+	Command(c *Connection, text string) ([]interface{}, error)
+	// Batch runs SQL script, in a transaction if requested, and returns result of its last or RETURN statement.
+	Batch(c *Connection, transaction bool, script []string) ([]interface{}, error)
+	// Document fetches record of given RID, or nil if there is no such record.
+	Document(c *Connection, rid string) (interface{}, error)
+	// Database fetches description of the database (its classes and indexes), as returned by /database REST endpoint.
+	Database(c *Connection) (interface{}, error)
+}
+
+/* RESTTransport is Transport using OrientDB REST API, with Client of the connection. Dialect is the major version of
+OrientDB (2 or 3) whose REST API is spoken; when it's 0, Authenticate detects it from the version of the server, and
+fails if the server doesn't report it. In dialect 3, commands are sent in JSON body; projections lose their #-1:-1
+RID and single values returned by DELETE and UPDATE ... RETURN BEFORE/AFTER @field are converted to the shape of 2.x
+results, while other results are kept as they are. */
+type RESTTransport struct {
+	Scheme  string // http if empty
+	Dialect int
+}
+
+// restURL returns address of the path in REST API of the connection's server.
+func (c *Connection) restURL(path string) string {
+	scheme := "http"
+	if t, ok := (*c).Transport.(*RESTTransport); ok && t.Scheme != "" {
+		scheme = t.Scheme
+	}
+	return fmt.Sprintf("%s://%s:%s/%s", scheme, (*c).Server, (*c).Port, path)
+}
+
+type respAndError struct {
+	resp *http.Response
+	err  error
+}
+
+/* doRequest spawns a goroutine, which should do a request, and handles timeout. Requests which failed to connect to
+the server are retried up to Retries times, with growing delay. */
+func (c *Connection) doRequest(req *http.Request) (*http.Response, error) {
+	requestDone := make(chan respAndError)
+	go func() {
+		resp, err := (*c).Client.Do(req)
+		for attempt := 1; attempt <= (*c).Retries && isDialError(err); attempt++ {
+			time.Sleep(time.Duration(attempt) * retryDelay)
+			if req.GetBody != nil {
+				req.Body, _ = req.GetBody()
+			}
+			resp, err = (*c).Client.Do(req)
+		}
+		requestDone <- respAndError{resp, err}
+		return
+	}()
+	var result respAndError
+	result = <-requestDone
+	return result.resp, result.err
+}
+
+// retryDelay is the delay before the first retry of a request; next retries wait longer.
+const retryDelay = 100 * time.Millisecond
+
+// isDialError tells whether the error happened when connecting to the server, so the request wasn't sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+/* sendJson sends request with given body (which can be nil) to the server path, reconnecting once if the session has
+expired, and returns HTTP status and decoded JSON response. */
+func (t *RESTTransport) sendJson(c *Connection, method, path string, body []byte) (int, interface{}, error) {
+	addr := c.restURL(path)
+	retriedAuth := false
+RetryRequest:
+	req, err := http.NewRequest(method, addr, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept-Encoding", "gzip,deflate")
+
+	resp, err := (*c).doRequest(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && !retriedAuth {
+		resp.Body.Close()
+		t.Authenticate(c)
+		retriedAuth = true
+		goto RetryRequest
+	}
+	buff, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, nil, err
+	}
+	respJson, err := decodeJson(buff)
+	if err != nil && resp.StatusCode >= 300 {
+		respJson = strings.TrimSpace(string(buff)) // e.g. stack trace sent as plain text
+	}
+	return resp.StatusCode, respJson, nil
+}
+
+/* commandResult returns ["result"] array from decoded server response, or the first database error encountered. What
+describes the request in error messages. */
+func commandResult(respJson interface{}, what string) ([]interface{}, error) {
+	if text, ok := respJson.(string); ok && text != "" {
+		return nil, errors.New(fmt.Sprintf("%s failed, server error: %q", what, text))
+	}
+	chill := chillson.Son{respJson}
+	firstErr, err := chill.GetObj("[errors][0]")
+	if err == nil {
+		chill = chillson.Son{firstErr} // extract from ['errors'][0]
+		reason, _ := chill.GetStr("[reason]")
+		content, _ := chill.GetStr("[content]")
+		return nil, errors.New(fmt.Sprintf("%s failed, server error reason: %v; content: %q", what, reason, content))
+	}
+	result, err := chill.GetArr("[result]")
+	if err == nil {
+		return result, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unable to extract result from server response to %s, response body: %v", what, respJson))
+}
+
+func (t *RESTTransport) Authenticate(c *Connection) error {
+	addr := c.restURL("connect/" + (*c).Database)
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth((*c).Username, (*c).Password)
+
+	resp, err := (*c).doRequest(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 204 {
+		return errors.New(fmt.Sprintf("Connecting to OrientDB: HTTP status %v, perhaps wrong credentials", resp.Status))
+	}
+	if cookies := (*c).Client.Jar.Cookies(req.URL); len(cookies) != 1 || strings.Index(cookies[0].String(), "OSESSIONID=") == -1 {
+		return errors.New("Connecting to OrientDB: connection ok, but OSESSIONID cookie not present in server response, wrong address?")
+	}
+	if t.Dialect == 0 {
+		return t.detectDialect(c, resp.Header.Get("Server"))
+	}
+	return err // nil if all OK
+}
+
+// versionPattern matches major version of OrientDB, e.g. in "OrientDB Server v.3.0.30" or "2.2.37".
+var versionPattern = regexp.MustCompile(`(?:^|v\.)(\d+)\.\d+`)
+
+/* detectDialect sets Dialect from major version of the server given in Server header of its responses or, if it isn't
+there (e.g. behind a proxy), reported by /database endpoint. It fails if the version can't be found in either, so
+Dialect has to be set explicitly for such servers. */
+func (t *RESTTransport) detectDialect(c *Connection, serverHeader string) error {
+	version := serverHeader
+	if !versionPattern.MatchString(version) {
+		t.Dialect = 2 // set before asking /database, which could reconnect
+		_, respJson, err := t.sendJson(c, "GET", "database/"+(*c).Database, nil)
+		t.Dialect = 0
+		if err != nil {
+			return errors.New(fmt.Sprintf("Connecting to OrientDB: detecting server version: %v", err))
+		}
+		chill := chillson.Son{respJson}
+		version, _ = chill.GetStr("[server][version]")
+	}
+	m := versionPattern.FindStringSubmatch(version)
+	if m == nil {
+		return errors.New(fmt.Sprintf(
+			"Connecting to OrientDB: can't tell server version from %q, set Dialect of RESTTransport", version))
+	}
+	if major, _ := strconv.Atoi(m[1]); major >= 3 {
+		t.Dialect = 3
+	} else {
+		t.Dialect = 2
+	}
+	return nil
+}
+
+/* valuePattern matches statements sent by the driver whose result is a single value: count of DELETE, a field returned
+by UPDATE ... RETURN BEFORE/AFTER @field, and the outcome of such UPDATE returned from a batch as $updated. */
+var valuePattern = regexp.MustCompile(`(?i)^\s*DELETE\b|\bRETURN\s+(BEFORE|AFTER)\s+@\w+\b|^\s*RETURN\s+\$updated\s*$`)
+
+/* legacyResult converts result of a statement returned by OrientDB 3.x to the shape of 2.x results, which the driver
+expects. Projections lose their #-1:-1 RID, and results of statements matching valuePattern (e.g. {"count": 2} of
+DELETE or {"@version": 3} of UPDATE ... RETURN AFTER @version) give their single value in value field. Results of
+other statements are kept as 3.x returns them. */
+func legacyResult(res []interface{}, statement string) []interface{} {
+	for ind, rawRec := range res {
+		rec, ok := rawRec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if rid, _ := rec["@rid"].(string); strings.HasPrefix(rid, "#-") {
+			delete(rec, "@rid")
+		}
+		if !valuePattern.MatchString(statement) {
+			continue
+		}
+		var names []string
+		for name, val := range rec {
+			if name != "@type" && name != "@fieldTypes" && !(name == "@version" && val == 0.0) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 1 {
+			res[ind] = map[string]interface{}{"@type": "d", "@version": 0.0, "value": rec[names[0]]}
+		}
+	}
+	return res
+}
+
+func (t *RESTTransport) Command(c *Connection, text string) ([]interface{}, error) {
+	if t.Dialect < 3 {
+		_, respJson, err := t.sendJson(c, "POST", fmt.Sprintf("command/%s/sql/%s", (*c).Database, url.QueryEscape(text)), nil)
+		if err != nil {
+			return nil, err
+		}
+		return commandResult(respJson, fmt.Sprintf("Command %v", text))
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"command": text, "parameters": map[string]interface{}{}, "mode": "document", "limit": -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, respJson, err := t.sendJson(c, "POST", fmt.Sprintf("command/%s/sql", (*c).Database), body)
+	if err != nil {
+		return nil, err
+	}
+	res, err := commandResult(respJson, fmt.Sprintf("Command %v", text))
+	if err != nil {
+		return nil, err
+	}
+	return legacyResult(res, text), nil
+}
+
+func (t *RESTTransport) Batch(c *Connection, transaction bool, script []string) ([]interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"transaction": transaction,
+		"operations": []interface{}{
+			map[string]interface{}{"type": "script", "language": "sql", "script": script},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, respJson, err := t.sendJson(c, "POST", "batch/"+(*c).Database, body)
+	if err != nil {
+		return nil, err
+	}
+	res, err := commandResult(respJson, fmt.Sprintf("Batch %q", script))
+	if err != nil || t.Dialect < 3 || len(script) == 0 {
+		return res, err
+	}
+	return legacyResult(res, script[len(script)-1]), nil
+}
+
+func (t *RESTTransport) Document(c *Connection, rid string) (interface{}, error) {
+	return t.load(c, fmt.Sprintf("document/%s/%s", (*c).Database, strings.TrimPrefix(rid, "#")), true)
+}
+
+func (t *RESTTransport) Database(c *Connection) (interface{}, error) {
+	return t.load(c, "database/"+(*c).Database, false)
+}
+
+// load fetches resource of REST API; with missingOk, nil is returned if it's not found instead of an error.
+func (t *RESTTransport) load(c *Connection, path string, missingOk bool) (interface{}, error) {
+	status, respJson, err := t.sendJson(c, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound && missingOk {
+		return nil, nil
+	}
+	if status >= 300 {
+		_, err = commandResult(respJson, "Loading "+path)
+		if err == nil {
+			err = errors.New(fmt.Sprintf("Loading %s: HTTP status %v", path, status))
+		}
+		return nil, err
+	}
+	return respJson, nil
+}
@@ -1,9 +1,116 @@
 package sheikh
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Greatest integer magnitude that float64 can hold without losing precision (2^53).
+const maxExactFloat = 1 << 53
 
 // Convert a thing to OrientDB-syntax string representation.
 func toOdbRepr(thing interface{}) string {
 	ret, _ := json.Marshal(thing)
 	return string(ret)
 }
+
+/* decodeJson decodes server response. Numbers are kept as float64 when they can be represented exactly,
+so they are still readable by chillson; larger integers (e.g. longs above 2^53) become int64 or uint64. */
+func decodeJson(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var ret interface{}
+	if err := dec.Decode(&ret); err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(ret), nil
+}
+
+// Replace json.Number values in decoded JSON with Go numeric types (see decodeJson).
+func normalizeNumbers(thing interface{}) interface{} {
+	switch t := thing.(type) {
+	case map[string]interface{}:
+		for key, val := range t {
+			t[key] = normalizeNumbers(val)
+		}
+	case []interface{}:
+		for ind, val := range t {
+			t[ind] = normalizeNumbers(val)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			if i > maxExactFloat || i < -maxExactFloat {
+				return i
+			}
+			return float64(i)
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return u
+		}
+		f, _ := t.Float64()
+		return f
+	}
+	return thing
+}
+
+// deepCopy copies maps and slices decoded from JSON (or set as properties), so they can be modified independently.
+func deepCopy(thing interface{}) interface{} {
+	switch t := thing.(type) {
+	case map[string]interface{}:
+		if t == nil {
+			return t
+		}
+		ret := make(map[string]interface{}, len(t))
+		for key, val := range t {
+			ret[key] = deepCopy(val)
+		}
+		return ret
+	case []interface{}:
+		if t == nil {
+			return t
+		}
+		ret := make([]interface{}, len(t))
+		for ind, val := range t {
+			ret[ind] = deepCopy(val)
+		}
+		return ret
+	}
+	return thing
+}
+
+/* bindParams substitutes ? placeholders in SQL condition with OrientDB representations of params, in order.
+Question marks inside quoted strings are left intact. */
+func bindParams(condition string, params []interface{}) (string, error) {
+	var ret strings.Builder
+	var quote rune // quote character of the string literal we're in, if any
+	escaped := false
+	used := 0
+	for _, ch := range condition {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && ch == '\\':
+			escaped = true
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '?':
+			if used == len(params) {
+				return "", errors.New(fmt.Sprintf("Condition %q has more placeholders than %v params given", condition, len(params)))
+			}
+			ret.WriteString(toOdbRepr(params[used]))
+			used++
+			continue
+		}
+		ret.WriteRune(ch)
+	}
+	if used != len(params) {
+		return "", errors.New(fmt.Sprintf("Condition %q has %v placeholders, but %v params given", condition, used, len(params)))
+	}
+	return ret.String(), nil
+}
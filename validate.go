@@ -0,0 +1,177 @@
+package sheikh
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes violation of a schema constraint by a property.
+type FieldError struct {
+	Class, Field string
+	Constraint   string // type, mandatory, notnull, min, max, regexp or readonly
+	Message      string
+}
+
+func (fe FieldError) Error() string {
+	return fmt.Sprintf("%s.%s: %s", fe.Class, fe.Field, fe.Message)
+}
+
+// ValidationError lists all constraint violations found in a record; it's returned as error by validating methods.
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	var msgs []string
+	for _, fe := range ve {
+		msgs = append(msgs, fe.Error())
+	}
+	return "Validation failed: " + strings.Join(msgs, "; ")
+}
+
+/* EnableValidation makes InsertVertex, InsertEdge, UpdateVertex and UpdateEdge check records against the schema
+(usually obtained with Schema method) before sending them to the server. Errors are returned as ValidationError.
+Pass nil to disable validation. */
+func (c *Connection) EnableValidation(s *Schema) {
+	c.validation = s
+}
+
+// validate checks entry against the schema set with EnableValidation, if any.
+func (c *Connection) validate(entry *Doc, insert, edge bool) error {
+	if c.validation == nil {
+		return nil
+	}
+	if errs := c.validation.Validate(entry, insert, edge); len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+/* Validate checks properties of the entry against constraints of its class (including inherited ones): types,
+mandatory, notnull, min, max, regexp and readonly. With insert, all properties are checked, and mandatory ones have to
+be present; otherwise only properties changed since the last sync are checked, readonly ones can't be changed and
+mandatory ones can't be removed.
+With edge, out and in properties are skipped, as they are maintained by the driver. */
+func (s *Schema) Validate(entry *Doc, insert, edge bool) ValidationError {
+	var errs ValidationError
+	fail := func(p Property, constraint, format string, a ...interface{}) {
+		errs = append(errs, FieldError{entry.Class, p.Name, constraint, fmt.Sprintf(format, a...)})
+	}
+	changed := make(map[string]bool)
+	for _, label := range entry.diff {
+		changed[strings.SplitN(label, ".", 2)[0]] = true
+	}
+	for _, p := range s.AllProperties(entry.Class) {
+		if edge && (p.Name == "out" || p.Name == "in") {
+			continue
+		}
+		if !insert && !changed[p.Name] {
+			continue
+		}
+		val, present := entry.propsContainer[p.Name]
+		if !insert && p.ReadOnly {
+			fail(p, "readonly", "property is read-only")
+			continue
+		}
+		if !present { // on update, the property was removed since the last sync
+			if p.Mandatory {
+				fail(p, "mandatory", "mandatory property is missing")
+			}
+			continue
+		}
+		if val == nil {
+			if p.NotNull {
+				fail(p, "notnull", "property can't be null")
+			}
+			continue
+		}
+		if !typeMatches(p.Type, val) {
+			fail(p, "type", "%v (%T) is not a valid %s value", val, val, strings.ToUpper(p.Type))
+			continue
+		}
+		size, sized := valueSize(val)
+		if upper := strings.ToUpper(p.Type); upper == "DATE" || upper == "DATETIME" {
+			sized = false // limits are dates, not lengths
+		}
+		if min, err := strconv.ParseFloat(p.Min, 64); err == nil && sized && size < min {
+			fail(p, "min", "%v is below minimum %s", size, p.Min)
+		}
+		if max, err := strconv.ParseFloat(p.Max, 64); err == nil && sized && size > max {
+			fail(p, "max", "%v is above maximum %s", size, p.Max)
+		}
+		if str, ok := val.(string); ok && p.Regexp != "" {
+			re, err := regexp.Compile("^(?:" + p.Regexp + ")$")
+			if err == nil && !re.MatchString(str) {
+				fail(p, "regexp", "%q doesn't match %s", str, p.Regexp)
+			}
+		}
+	}
+	return errs
+}
+
+// typeMatches tells whether Go value can be stored in a property of given OrientDB type.
+func typeMatches(odbType string, val interface{}) bool {
+	rv := reflect.ValueOf(val)
+	kind := rv.Kind()
+	isInt := kind >= reflect.Int && kind <= reflect.Uintptr
+	isFloat := kind == reflect.Float32 || kind == reflect.Float64
+	switch strings.ToUpper(odbType) {
+	case "STRING":
+		return kind == reflect.String
+	case "BOOLEAN":
+		return kind == reflect.Bool
+	case "BYTE", "SHORT", "INTEGER", "LONG":
+		bits := map[string]uint{"BYTE": 8, "SHORT": 16, "INTEGER": 32, "LONG": 64}[strings.ToUpper(odbType)]
+		min, max := int64(-1)<<(bits-1), int64(1)<<(bits-1)-1 // compared exactly, float64 can't hold all of them
+		switch {
+		case kind >= reflect.Int && kind <= reflect.Int64:
+			return rv.Int() >= min && rv.Int() <= max
+		case isInt:
+			return rv.Uint() <= uint64(max)
+		case isFloat:
+			f, limit := rv.Float(), math.Ldexp(1, int(bits)-1)
+			return f == math.Trunc(f) && f >= -limit && f < limit
+		}
+		return false
+	case "FLOAT", "DOUBLE", "DECIMAL":
+		return isInt || isFloat
+	case "DATE", "DATETIME":
+		_, isTime := val.(time.Time)
+		return isTime || kind == reflect.String || isInt || isFloat
+	case "LINK":
+		str, ok := val.(string)
+		return ok && strings.HasPrefix(str, "#")
+	case "LINKLIST", "LINKSET", "EMBEDDEDLIST", "EMBEDDEDSET", "LINKBAG":
+		return kind == reflect.Slice || kind == reflect.Array
+	case "LINKMAP", "EMBEDDEDMAP":
+		return kind == reflect.Map
+	case "EMBEDDED":
+		return kind == reflect.Map || kind == reflect.Struct
+	case "BINARY":
+		return kind == reflect.String || (kind == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8)
+	}
+	return true // ANY, CUSTOM etc.
+}
+
+/* valueSize returns the number compared with min and max constraints: the value of numbers, length of strings and
+size of collections. */
+func valueSize(val interface{}) (float64, bool) {
+	rv := reflect.ValueOf(val)
+	switch kind := rv.Kind(); {
+	case kind >= reflect.Int && kind <= reflect.Int64:
+		return float64(rv.Int()), true
+	case kind >= reflect.Uint && kind <= reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		return rv.Float(), true
+	case kind == reflect.String:
+		return float64(utf8.RuneCountInString(rv.String())), true
+	case kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map:
+		return float64(rv.Len()), true
+	}
+	return 0, false
+}